@@ -0,0 +1,449 @@
+package wechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slrem/wechat/trader"
+)
+
+// 匹配方式
+const (
+	MatchExact     = "exact"     // 文本内容与Value完全相同
+	MatchContains  = "contains"  // 文本内容包含Value
+	MatchRegex     = "regex"     // 文本内容匹配正则Value
+	MatchEvent     = "event"     // 事件的EventKey等于Value，如菜单CLICK，Value不能为空
+	MatchSubscribe = "subscribe" // 关注事件(含扫码关注)
+	MatchAny       = "any"       // 任意非事件消息，用作默认回复，其他规则均未命中时才生效
+)
+
+// 回复类型
+const (
+	ReplyText     = "text"
+	ReplyImage    = "image"
+	ReplyVoice    = "voice"
+	ReplyNews     = "news"
+	ReplyMusic    = "music"
+	ReplyTransfer = "transfer_customer_service"
+)
+
+type AutoReplyMatch struct {
+	Type  string `json:"type" yaml:"type"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+type AutoReplyArticle struct {
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	PicUrl      string `json:"picurl,omitempty" yaml:"picurl,omitempty"`
+	Url         string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+type AutoReplyMusic struct {
+	Title        string `json:"title,omitempty" yaml:"title,omitempty"`
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"`
+	MusicUrl     string `json:"musicurl,omitempty" yaml:"musicurl,omitempty"`
+	HQMusicUrl   string `json:"hqmusicurl,omitempty" yaml:"hqmusicurl,omitempty"`
+	ThumbMediaId string `json:"thumb_media_id" yaml:"thumb_media_id"`
+}
+
+// 一条回复 按Type填写对应字段
+type AutoReplyContent struct {
+	Type      string             `json:"type" yaml:"type"`
+	Content   string             `json:"content,omitempty" yaml:"content,omitempty"`   // text
+	Template  string             `json:"template,omitempty" yaml:"template,omitempty"` // text，使用SetReplyTemplates设置的模板，优先于Content
+	MediaId   string             `json:"media_id,omitempty" yaml:"media_id,omitempty"` // image、voice
+	Articles  []AutoReplyArticle `json:"articles,omitempty" yaml:"articles,omitempty"` // news
+	Music     *AutoReplyMusic    `json:"music,omitempty" yaml:"music,omitempty"`       // music
+	KfAccount string             `json:"kf_account,omitempty" yaml:"kf_account,omitempty"`
+}
+
+// 自动回复规则 任一Match命中即回复
+// 被动回复只能回复一条，Random为true时随机选择一条Replies，否则使用第一条
+type AutoReplyRule struct {
+	Name     string             `json:"name" yaml:"name"`
+	Match    []AutoReplyMatch   `json:"match" yaml:"match"`
+	Replies  []AutoReplyContent `json:"replies" yaml:"replies"`
+	Random   bool               `json:"random,omitempty" yaml:"random,omitempty"`
+	Disabled bool               `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// 自动回复规则的来源
+type AutoReplyStore interface {
+	LoadAutoReplyRules() ([]AutoReplyRule, error)
+}
+
+// JSON文件中的规则 文件内容为AutoReplyRule数组
+/*
+	[
+		{"name": "hello", "match": [{"type": "contains", "value": "你好"}], "replies": [{"type": "text", "content": "你好"}]},
+		{"name": "welcome", "match": [{"type": "subscribe"}], "replies": [{"type": "text", "template": "welcome.tmpl"}]},
+		{"name": "kf", "match": [{"type": "regex", "value": "^(人工|客服)$"}], "replies": [{"type": "transfer_customer_service"}]}
+	]
+*/
+type AutoReplyFile string
+
+func (f AutoReplyFile) LoadAutoReplyRules() ([]AutoReplyRule, error) {
+	return AutoReplyDecoderFile{Path: string(f), Unmarshal: json.Unmarshal}.LoadAutoReplyRules()
+}
+
+// 用Unmarshal解析的规则文件 用于YAML等其他格式，本包不依赖具体的解析库
+/*
+	import "gopkg.in/yaml.v3"
+
+	store := wechat.AutoReplyDecoderFile{Path: "autoreply.yaml", Unmarshal: yaml.Unmarshal}
+
+	- name: hello
+	  match: [{type: contains, value: 你好}]
+	  replies: [{type: text, content: 你好}]
+*/
+type AutoReplyDecoderFile struct {
+	Path      string
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+func (f AutoReplyDecoderFile) LoadAutoReplyRules() (rules []AutoReplyRule, err error) {
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return
+	}
+	err = f.Unmarshal(b, &rules)
+	return
+}
+
+// 内存中的规则
+type AutoReplyRules []AutoReplyRule
+
+func (r AutoReplyRules) LoadAutoReplyRules() ([]AutoReplyRule, error) {
+	return r, nil
+}
+
+// 从公众号后台导入的规则 每次加载时调用get_current_autoreply_info
+type BackendAutoReplyStore struct {
+	t *trader.Trader
+}
+
+func NewBackendAutoReplyStore(t *trader.Trader) *BackendAutoReplyStore {
+	return &BackendAutoReplyStore{t: t}
+}
+
+func (s *BackendAutoReplyStore) LoadAutoReplyRules() (rules []AutoReplyRule, err error) {
+	info, err := s.t.GetCurrentAutoreplyInfo()
+	if err != nil {
+		return
+	}
+	return AutoReplyRulesFromInfo(info), nil
+}
+
+// 将后台的自动回复设置转换为规则 关键词规则在前，关注回复和默认回复在后
+// 后台的视频回复无法被动回复，会被忽略
+func AutoReplyRulesFromInfo(info trader.AutoreplyInfo) (rules []AutoReplyRule) {
+	for _, kr := range info.KeywordAutoreplyInfo.List {
+		rule := AutoReplyRule{Name: kr.RuleName, Random: kr.ReplyMode == "random_one"}
+		for _, k := range kr.KeywordListInfo {
+			m := AutoReplyMatch{Type: MatchContains, Value: k.Content}
+			if k.MatchMode == "equal" {
+				m.Type = MatchExact
+			}
+			rule.Match = append(rule.Match, m)
+		}
+		for _, c := range kr.ReplyListInfo {
+			if r, ok := autoReplyFromInfo(c); ok {
+				rule.Replies = append(rule.Replies, r)
+			}
+		}
+		if len(rule.Match) > 0 && len(rule.Replies) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	if info.IsAddFriendReplyOpen == 1 && info.AddFriendAutoreplyInfo != nil {
+		if r, ok := autoReplyFromInfo(*info.AddFriendAutoreplyInfo); ok {
+			rules = append(rules, AutoReplyRule{
+				Name:    "add_friend_autoreply",
+				Match:   []AutoReplyMatch{{Type: MatchSubscribe}},
+				Replies: []AutoReplyContent{r},
+			})
+		}
+	}
+	if info.IsAutoreplyOpen == 1 && info.MessageDefaultAutoreplyInfo != nil {
+		if r, ok := autoReplyFromInfo(*info.MessageDefaultAutoreplyInfo); ok {
+			rules = append(rules, AutoReplyRule{
+				Name:    "message_default_autoreply",
+				Match:   []AutoReplyMatch{{Type: MatchAny}},
+				Replies: []AutoReplyContent{r},
+			})
+		}
+	}
+	return
+}
+
+func autoReplyFromInfo(c trader.AutoreplyContent) (r AutoReplyContent, ok bool) {
+	switch c.Type {
+	case "text":
+		return AutoReplyContent{Type: ReplyText, Content: c.Content}, true
+	case "img":
+		return AutoReplyContent{Type: ReplyImage, MediaId: c.Content}, true
+	case "voice":
+		return AutoReplyContent{Type: ReplyVoice, MediaId: c.Content}, true
+	case "news":
+		if c.NewsInfo == nil || len(c.NewsInfo.List) == 0 {
+			return
+		}
+		n := c.NewsInfo.List[0]
+		return AutoReplyContent{Type: ReplyNews, Articles: []AutoReplyArticle{{
+			Title:       n.Title,
+			Description: n.Digest,
+			PicUrl:      n.CoverUrl,
+			Url:         n.ContentUrl,
+		}}}, true
+	}
+	return
+}
+
+type autoReplyRule struct {
+	AutoReplyRule
+	regexps []*regexp.Regexp
+}
+
+// 自动回复引擎 在Router.Find之前按顺序匹配规则，命中的规则直接回复，未命中时交给已注册的处理函数
+/*
+	ar, err := wechat.NewAutoReplyEngine(wechat.AutoReplyFile("autoreply.json"))
+	w.SetAutoReply(ar)
+	ar.Watch(time.Minute)
+*/
+type AutoReplyEngine struct {
+	store AutoReplyStore
+
+	mtx   sync.RWMutex
+	rules []autoReplyRule
+	rnd   *rand.Rand
+
+	// 为true时只在消息类型没有注册处理函数时才匹配规则
+	AutoReplyFallbackOnly bool
+	// 定时重新加载失败时调用，继续使用之前的规则
+	OnReloadError func(err error)
+
+	stop chan struct{}
+}
+
+func NewAutoReplyEngine(store AutoReplyStore) (e *AutoReplyEngine, err error) {
+	e = &AutoReplyEngine{
+		store: store,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	err = e.Reload()
+	return
+}
+
+// 从store重新加载规则 加载或校验失败时保留原有规则
+func (e *AutoReplyEngine) Reload() (err error) {
+	list, err := e.store.LoadAutoReplyRules()
+	if err != nil {
+		return
+	}
+	rules := make([]autoReplyRule, 0, len(list))
+	for _, r := range list {
+		if r.Disabled {
+			continue
+		}
+		if len(r.Replies) == 0 {
+			return fmt.Errorf("autoreply: rule %q has no reply", r.Name)
+		}
+		cr := autoReplyRule{AutoReplyRule: r}
+		for _, m := range r.Match {
+			switch m.Type {
+			case MatchRegex:
+				var re *regexp.Regexp
+				re, err = regexp.Compile(m.Value)
+				if err != nil {
+					return fmt.Errorf("autoreply: rule %q: %v", r.Name, err)
+				}
+				cr.regexps = append(cr.regexps, re)
+			case MatchExact, MatchContains, MatchEvent:
+				if m.Value == "" {
+					return fmt.Errorf("autoreply: rule %q: empty %s match", r.Name, m.Type)
+				}
+			case MatchSubscribe, MatchAny:
+			default:
+				return fmt.Errorf("autoreply: rule %q: unknown match type %q", r.Name, m.Type)
+			}
+		}
+		rules = append(rules, cr)
+	}
+	e.mtx.Lock()
+	e.rules = rules
+	e.mtx.Unlock()
+	return
+}
+
+// 当前生效的规则
+func (e *AutoReplyEngine) Rules() []AutoReplyRule {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	rules := make([]AutoReplyRule, len(e.rules))
+	for i, r := range e.rules {
+		rules[i] = r.AutoReplyRule
+	}
+	return rules
+}
+
+// 按顺序返回第一条命中的规则 MatchAny只在其他匹配方式均未命中时生效
+func (e *AutoReplyEngine) Match(r Request) (rule AutoReplyRule, ok bool) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	for _, fallback := range []bool{false, true} {
+		for _, cr := range e.rules {
+			if cr.match(r, fallback) {
+				return cr.AutoReplyRule, true
+			}
+		}
+	}
+	return
+}
+
+// fallback为false时忽略MatchAny，为true时只看MatchAny
+func (cr *autoReplyRule) match(r Request, fallback bool) bool {
+	t := r.MsgType()
+	text := t == TextType
+	event := r.Event() != ""
+	re := 0
+	for _, m := range cr.Match {
+		if (m.Type == MatchAny) != fallback {
+			if m.Type == MatchRegex {
+				re++
+			}
+			continue
+		}
+		switch m.Type {
+		case MatchExact:
+			if text && strings.TrimSpace(r.Content()) == m.Value {
+				return true
+			}
+		case MatchContains:
+			if text && strings.Contains(r.Content(), m.Value) {
+				return true
+			}
+		case MatchRegex:
+			ok := text && cr.regexps[re].MatchString(r.Content())
+			re++
+			if ok {
+				return true
+			}
+		case MatchEvent:
+			if event && r.EventKey() == m.Value {
+				return true
+			}
+		case MatchSubscribe:
+			if t == SubscribeEventType || t == ScanSubscribeEventType {
+				return true
+			}
+		case MatchAny:
+			if !event && t != UnknownType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按规则回复
+func (e *AutoReplyEngine) Reply(c Context, rule AutoReplyRule) error {
+	if len(rule.Replies) == 0 {
+		return c.Response().Success()
+	}
+	r := rule.Replies[0]
+	if rule.Random && len(rule.Replies) > 1 {
+		e.mtx.Lock()
+		r = rule.Replies[e.rnd.Intn(len(rule.Replies))]
+		e.mtx.Unlock()
+	}
+	res := c.Response()
+	switch r.Type {
+	case ReplyText:
+		if r.Template != "" {
+			return res.TextTemplate(r.Template, nil)
+		}
+		return res.Text(r.Content)
+	case ReplyImage:
+		return res.Image(r.MediaId)
+	case ReplyVoice:
+		return res.Voice(r.MediaId)
+	case ReplyNews:
+		items := make([]ArticleItem, len(r.Articles))
+		for i, a := range r.Articles {
+			items[i] = NewArticleItem(a.Title, a.Description, a.PicUrl, a.Url)
+		}
+		return res.Article(items...)
+	case ReplyMusic:
+		if r.Music == nil {
+			return fmt.Errorf("autoreply: rule %q: missing music", rule.Name)
+		}
+		m := r.Music
+		return res.Music(NewMusic(m.Title, m.Description, m.MusicUrl, m.HQMusicUrl, m.ThumbMediaId))
+	case ReplyTransfer:
+		return res.TransferCustomerService(r.KfAccount)
+	}
+	return fmt.Errorf("autoreply: rule %q: unknown reply type %q", rule.Name, r.Type)
+}
+
+// 命中规则时返回回复该规则的Handler，否则返回nil
+func (e *AutoReplyEngine) find(r Request) Handler {
+	rule, ok := e.Match(r)
+	if !ok {
+		return nil
+	}
+	return func(c Context) error {
+		return e.Reply(c, rule)
+	}
+}
+
+// 每隔interval重新加载一次规则，直到调用Stop
+func (e *AutoReplyEngine) Watch(interval time.Duration) {
+	e.mtx.Lock()
+	if e.stop != nil {
+		e.mtx.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	e.stop = stop
+	e.mtx.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := e.Reload(); err != nil && e.OnReloadError != nil {
+					e.OnReloadError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (e *AutoReplyEngine) Stop() {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+// 设置自动回复引擎 为nil时关闭自动回复
+func (w *Wechat) SetAutoReply(e *AutoReplyEngine) {
+	w.autoReply = e
+}
+
+func (w *Wechat) AutoReply() *AutoReplyEngine {
+	return w.autoReply
+}
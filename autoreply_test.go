@@ -0,0 +1,247 @@
+package wechat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slrem/wechat/trader"
+)
+
+func textXML(content string) string {
+	return fmt.Sprintf(`<xml><ToUserName>gh</ToUserName><FromUserName>u</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content><![CDATA[%s]]></Content><MsgId>1</MsgId></xml>`, content)
+}
+
+func eventXML(event, key string) string {
+	return fmt.Sprintf(`<xml><ToUserName>gh</ToUserName><FromUserName>u</FromUserName><CreateTime>1</CreateTime><MsgType>event</MsgType><Event>%s</Event><EventKey>%s</EventKey></xml>`, event, key)
+}
+
+func textRule(name string, reply string, match ...AutoReplyMatch) AutoReplyRule {
+	return AutoReplyRule{Name: name, Match: match, Replies: []AutoReplyContent{{Type: ReplyText, Content: reply}}}
+}
+
+// 可在测试中替换内容的规则来源
+type testAutoReplyStore struct {
+	rules []AutoReplyRule
+	err   error
+}
+
+func (s *testAutoReplyStore) LoadAutoReplyRules() ([]AutoReplyRule, error) {
+	return s.rules, s.err
+}
+
+func TestAutoReplyMatch(t *testing.T) {
+	e, err := NewAutoReplyEngine(AutoReplyRules{
+		textRule("default", "", AutoReplyMatch{Type: MatchAny}),
+		textRule("exact", "", AutoReplyMatch{Type: MatchExact, Value: "你好"}),
+		textRule("price", "", AutoReplyMatch{Type: MatchContains, Value: "价格"}),
+		textRule("kf", "", AutoReplyMatch{Type: MatchRegex, Value: "^(人工|客服)$"}),
+		textRule("help", "", AutoReplyMatch{Type: MatchAny}, AutoReplyMatch{Type: MatchExact, Value: "帮助"}),
+		textRule("menu", "", AutoReplyMatch{Type: MatchEvent, Value: "V1001"}),
+		textRule("welcome", "", AutoReplyMatch{Type: MatchSubscribe}),
+		textRule("hello", "", AutoReplyMatch{Type: MatchContains, Value: "你好"}),
+		{Name: "disabled", Match: []AutoReplyMatch{{Type: MatchContains, Value: "关闭"}}, Disabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		xml  string
+		want string
+	}{
+		{"exact", textXML("你好"), "exact"},
+		{"exact trims space", textXML(" 你好 "), "exact"},
+		{"contains after exact", textXML("你好啊"), "hello"},
+		{"contains", textXML("价格多少"), "price"},
+		{"regex", textXML("客服"), "kf"},
+		{"regex anchored", textXML("找客服"), "default"},
+		{"any fallback", textXML("随便说说"), "default"},
+		{"exact beats earlier any", textXML("帮助"), "help"},
+		{"disabled", textXML("关闭"), "default"},
+		{"non-text any", `<xml><FromUserName>u</FromUserName><MsgType>image</MsgType><PicUrl>p</PicUrl></xml>`, "default"},
+		{"click", eventXML("CLICK", "V1001"), "menu"},
+		{"other click", eventXML("CLICK", "V1002"), ""},
+		{"subscribe", eventXML("subscribe", ""), "welcome"},
+		{"scan subscribe", eventXML("subscribe", "qrscene_1"), "welcome"},
+		{"unsubscribe", eventXML("unsubscribe", ""), ""},
+	}
+	for _, tt := range tests {
+		c, _ := newTestContext(t, tt.xml)
+		rule, ok := e.Match(c.Request())
+		if ok != (tt.want != "") || rule.Name != tt.want {
+			t.Errorf("%s: matched %q (%v), want %q", tt.name, rule.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestAutoReplyReload(t *testing.T) {
+	store := &testAutoReplyStore{rules: []AutoReplyRule{textRule("a", "", AutoReplyMatch{Type: MatchExact, Value: "a"})}}
+	e, err := NewAutoReplyEngine(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func() (s []string) {
+		for _, r := range e.Rules() {
+			s = append(s, r.Name)
+		}
+		return
+	}
+
+	store.rules = []AutoReplyRule{textRule("b", "", AutoReplyMatch{Type: MatchExact, Value: "b"})}
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := newTestContext(t, textXML("a"))
+	if _, ok := e.Match(c.Request()); ok {
+		t.Error("old rule still matches after reload")
+	}
+	c, _ = newTestContext(t, textXML("b"))
+	if rule, ok := e.Match(c.Request()); !ok || rule.Name != "b" {
+		t.Errorf("new rule not matched: %q", rule.Name)
+	}
+
+	invalid := []struct {
+		name  string
+		rules []AutoReplyRule
+		err   error
+	}{
+		{"bad regex", []AutoReplyRule{textRule("x", "", AutoReplyMatch{Type: MatchRegex, Value: "("})}, nil},
+		{"empty exact", []AutoReplyRule{textRule("x", "", AutoReplyMatch{Type: MatchExact})}, nil},
+		{"empty event", []AutoReplyRule{textRule("x", "", AutoReplyMatch{Type: MatchEvent})}, nil},
+		{"unknown type", []AutoReplyRule{textRule("x", "", AutoReplyMatch{Type: "fuzzy", Value: "x"})}, nil},
+		{"no reply", []AutoReplyRule{{Name: "x", Match: []AutoReplyMatch{{Type: MatchAny}}}}, nil},
+		{"store error", nil, errors.New("unavailable")},
+	}
+	for _, tt := range invalid {
+		store.rules, store.err = tt.rules, tt.err
+		if err := e.Reload(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+		if got := names(); !reflect.DeepEqual(got, []string{"b"}) {
+			t.Errorf("%s: rules replaced with %v", tt.name, got)
+		}
+	}
+}
+
+func TestAutoReplyRulesFromInfo(t *testing.T) {
+	info := trader.AutoreplyInfo{
+		IsAddFriendReplyOpen:        1,
+		IsAutoreplyOpen:             1,
+		AddFriendAutoreplyInfo:      &trader.AutoreplyContent{Type: "text", Content: "欢迎"},
+		MessageDefaultAutoreplyInfo: &trader.AutoreplyContent{Type: "img", Content: "media1"},
+		KeywordAutoreplyInfo: trader.KeywordAutoreplyInfoList{List: []trader.KeywordAutoreplyRule{
+			{
+				RuleName:  "kw",
+				ReplyMode: "random_one",
+				KeywordListInfo: []trader.AutoreplyKeyword{
+					{Type: "text", MatchMode: "equal", Content: "你好"},
+					{Type: "text", MatchMode: "contain", Content: "价格"},
+				},
+				ReplyListInfo: []trader.AutoreplyContent{
+					{Type: "text", Content: "hi"},
+					{Type: "voice", Content: "media2"},
+					{Type: "video", Content: "media3"},
+					{Type: "news", NewsInfo: &trader.AutoreplyNewsList{List: []trader.AutoreplyNews{
+						{Title: "t1", Digest: "d1", CoverUrl: "c1", ContentUrl: "u1"},
+						{Title: "t2"},
+					}}},
+				},
+			},
+			{
+				RuleName:        "video only",
+				ReplyMode:       "reply_all",
+				KeywordListInfo: []trader.AutoreplyKeyword{{Type: "text", MatchMode: "contain", Content: "视频"}},
+				ReplyListInfo:   []trader.AutoreplyContent{{Type: "video", Content: "media4"}},
+			},
+		}},
+	}
+	want := []AutoReplyRule{
+		{
+			Name:   "kw",
+			Random: true,
+			Match:  []AutoReplyMatch{{Type: MatchExact, Value: "你好"}, {Type: MatchContains, Value: "价格"}},
+			Replies: []AutoReplyContent{
+				{Type: ReplyText, Content: "hi"},
+				{Type: ReplyVoice, MediaId: "media2"},
+				{Type: ReplyNews, Articles: []AutoReplyArticle{{Title: "t1", Description: "d1", PicUrl: "c1", Url: "u1"}}},
+			},
+		},
+		{Name: "add_friend_autoreply", Match: []AutoReplyMatch{{Type: MatchSubscribe}}, Replies: []AutoReplyContent{{Type: ReplyText, Content: "欢迎"}}},
+		{Name: "message_default_autoreply", Match: []AutoReplyMatch{{Type: MatchAny}}, Replies: []AutoReplyContent{{Type: ReplyImage, MediaId: "media1"}}},
+	}
+	if got := AutoReplyRulesFromInfo(info); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	info.IsAddFriendReplyOpen, info.IsAutoreplyOpen = 0, 0
+	if got := AutoReplyRulesFromInfo(info); len(got) != 1 {
+		t.Errorf("closed replies still imported: %+v", got)
+	}
+}
+
+func TestAutoReplyDecoderFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	b, _ := json.Marshal([]AutoReplyRule{textRule("a", "A", AutoReplyMatch{Type: MatchExact, Value: "a"})})
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	store := AutoReplyDecoderFile{Path: path, Unmarshal: func(data []byte, v interface{}) error {
+		calls++
+		return json.Unmarshal(data, v)
+	}}
+	for _, s := range []AutoReplyStore{store, AutoReplyFile(path)} {
+		rules, err := s.LoadAutoReplyRules()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 || rules[0].Name != "a" || rules[0].Replies[0].Content != "A" {
+			t.Errorf("%T: got %+v", s, rules)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Unmarshal called %d times", calls)
+	}
+}
+
+func TestAutoReplyRoute(t *testing.T) {
+	e, err := NewAutoReplyEngine(AutoReplyRules{
+		textRule("hello", "auto", AutoReplyMatch{Type: MatchExact, Value: "你好"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Wechat{router: NewRouter()}
+	w.Text(func(c Context) error { return c.Response().Text("handler") })
+	w.SetAutoReply(e)
+
+	tests := []struct {
+		fallbackOnly bool
+		xml          string
+		want         string
+	}{
+		{false, textXML("你好"), "auto"},
+		{false, textXML("再见"), "handler"},
+		{false, eventXML("CLICK", "x"), "success"},
+		{true, textXML("你好"), "handler"},
+		{true, textXML("再见"), "handler"},
+	}
+	for _, tt := range tests {
+		e.AutoReplyFallbackOnly = tt.fallbackOnly
+		c, rec := newTestContext(t, tt.xml)
+		c.wc = w
+		w.route(c)
+		if err := c.handler(c); err != nil {
+			t.Fatal(err)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("fallbackOnly=%v %s: reply %s, want %s", tt.fallbackOnly, tt.xml, body, tt.want)
+		}
+	}
+}
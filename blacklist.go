@@ -0,0 +1,98 @@
+package wechat
+
+import (
+	"sync"
+
+	"github.com/slrem/wechat/trader"
+)
+
+// 本地缓存的黑名单 配合Middleware使黑名单用户的消息不进入处理器
+type Blacklist struct {
+	w   *Wechat
+	mtx sync.RWMutex
+	m   map[string]bool
+}
+
+func NewBlacklist(w *Wechat) *Blacklist {
+	return &Blacklist{w: w, m: make(map[string]bool)}
+}
+
+// 从微信拉取全部黑名单替换本地缓存
+func (bl *Blacklist) Refresh() (err error) {
+	openids, err := bl.w.Trader().GetAllBlacklist()
+	if err != nil {
+		return
+	}
+	m := make(map[string]bool, len(openids))
+	for _, id := range openids {
+		m[id] = true
+	}
+	bl.mtx.Lock()
+	bl.m = m
+	bl.mtx.Unlock()
+	return
+}
+
+func (bl *Blacklist) Contains(openid string) bool {
+	bl.mtx.RLock()
+	defer bl.mtx.RUnlock()
+	return bl.m[openid]
+}
+
+func (bl *Blacklist) Len() int {
+	bl.mtx.RLock()
+	defer bl.mtx.RUnlock()
+	return len(bl.m)
+}
+
+// 拉黑用户并更新本地缓存 部分失败时只缓存成功的用户
+func (bl *Blacklist) Block(openids ...string) error {
+	err := bl.w.Trader().BatchBlacklist(openids)
+	bl.update(openids, err, true)
+	return err
+}
+
+// 取消拉黑并更新本地缓存
+func (bl *Blacklist) Unblock(openids ...string) error {
+	err := bl.w.Trader().BatchUnblacklist(openids)
+	bl.update(openids, err, false)
+	return err
+}
+
+func (bl *Blacklist) update(openids []string, err error, blocked bool) {
+	failed := make(map[string]bool)
+	if be, ok := err.(*trader.BatchError); ok {
+		for _, c := range be.Chunks {
+			for _, id := range c.OpenIds {
+				failed[id] = true
+			}
+		}
+	} else if err != nil {
+		return
+	}
+
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+	for _, id := range openids {
+		if failed[id] {
+			continue
+		}
+		if blocked {
+			bl.m[id] = true
+		} else {
+			delete(bl.m, id)
+		}
+	}
+}
+
+// 中间件 黑名单用户的消息直接回复success 用法: w.Use(blacklist.Middleware())
+func (bl *Blacklist) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) error {
+			if bl.Contains(c.Request().FromUserName()) {
+				return c.Response().Success()
+			}
+			return next(c)
+		}
+	}
+}
@@ -1,12 +1,26 @@
 package wechat
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/slrem/wechat/metrics"
+)
 
 type Context interface {
 	Wechat() *Wechat
 	Request() Request
 	Response() Response
 	SetHandler(h Handler)
+	HTTPRequest() *http.Request
+
+	// 解密后的原始请求XML，解密失败时为原始请求体
+	RawRequest() []byte
+	// 已写入的回复(加密前的明文)，未回复时为nil
+	Reply() []byte
+	// 已写入回复的类型，如text、news、success，未回复时为空
+	ReplyType() string
+	// 是否已写入回复
+	Replied() bool
 }
 
 type Request interface {
@@ -15,6 +29,7 @@ type Request interface {
 	CreateTime() int
 	MsgType() MsgType
 	Content() string
+	BizMsgMenuId() string
 	MsgId() int64
 	PicUrl() string
 	MediaId() string
@@ -41,6 +56,22 @@ type Request interface {
 	SendLocationInfo() SendLocationInfo
 
 	Status() string
+
+	MassMsgId() int64
+	TotalCount() int
+	FilterCount() int
+	SentCount() int
+	ErrorCount() int
+	CopyrightCheckResult() CopyrightCheckResult
+	ArticleUrlResult() []ArticleUrlItem
+
+	KfAccount() string
+	FromKfAccount() string
+	ToKfAccount() string
+
+	SubscribeMsgPopupEvent() []SubscribeMsgItem
+	SubscribeMsgChangeEvent() []SubscribeMsgItem
+	SubscribeMsgSentEvent() []SubscribeMsgItem
 }
 
 type Response interface {
@@ -54,6 +85,10 @@ type Response interface {
 	Video(video Video) error
 	Music(music Music) error
 	Article(articles ...ArticleItem) error
+	TransferCustomerService(kfAccount string) error
+	// 用SetReplyTemplates设置的模板渲染后回复
+	TextTemplate(name string, data interface{}) error
+	ArticleTemplate(name string, data interface{}) error
 }
 
 type context struct {
@@ -63,9 +98,21 @@ type context struct {
 	wc  *Wechat
 	dr  defaultResponse
 
+	raw   []byte
+	reply replyRecord
+
 	handler Handler
 }
 
+// defaultResponse写入的回复
+type replyRecord struct {
+	data    []byte
+	msgType string
+	written bool
+	// TextOverflowSplit时待发送的客服消息
+	followUp []string
+}
+
 func newContext(w http.ResponseWriter, r *http.Request, wc *Wechat) (c *context) {
 	c = &context{
 		r:   r,
@@ -74,21 +121,45 @@ func newContext(w http.ResponseWriter, r *http.Request, wc *Wechat) (c *context)
 		dft: &defaultRequestMessage{},
 	}
 
-	c.dr = defaultResponse{c: c, w: w}
+	c.dr = defaultResponse{c: c, w: w, reply: &c.reply}
 
 	return
 }
 
 func (c *context) parse() (err error) {
 	data, err := c.wc.body(c.r)
+	c.raw = data
 	if err != nil {
 		return
 	}
 
 	err = c.dft.Unmarshal(data)
+	if err != nil {
+		c.wc.Metrics().Inc(metrics.CallbackRejectedTotal, "reason", "parse")
+	}
 	return
 }
 
+func (c *context) HTTPRequest() *http.Request {
+	return c.r
+}
+
+func (c *context) RawRequest() []byte {
+	return c.raw
+}
+
+func (c *context) Reply() []byte {
+	return c.reply.data
+}
+
+func (c *context) ReplyType() string {
+	return c.reply.msgType
+}
+
+func (c *context) Replied() bool {
+	return c.reply.written
+}
+
 func (c *context) Handler() Handler {
 	return c.handler
 }
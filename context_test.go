@@ -0,0 +1,17 @@
+package wechat
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// 用回调XML构造的context 回复写入rec
+func newTestContext(t *testing.T, xml string) (*context, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := newContext(rec, httptest.NewRequest("POST", "/", nil), &Wechat{})
+	if err := c.dft.Unmarshal([]byte(xml)); err != nil {
+		t.Fatal(err)
+	}
+	return c, rec
+}
@@ -0,0 +1,110 @@
+package wechat
+
+import (
+	"sync"
+
+	"github.com/slrem/wechat/trader"
+)
+
+// 本地关注者表 以全量快照初始化，通过关注/取消关注事件保持更新
+type FollowerTable struct {
+	w   *Wechat
+	mtx sync.RWMutex
+	m   trader.FollowerSnapshot
+
+	// 新关注时是否异步拉取用户基本信息
+	FetchInfo bool
+	// 表中记录变化时调用
+	OnChange func(rec trader.FollowerRecord)
+}
+
+func NewFollowerTable(w *Wechat, snapshot trader.FollowerSnapshot) *FollowerTable {
+	ft := &FollowerTable{w: w}
+	ft.Reset(snapshot)
+	return ft
+}
+
+// 用新的全量快照替换表中数据
+func (ft *FollowerTable) Reset(snapshot trader.FollowerSnapshot) {
+	m := make(trader.FollowerSnapshot, len(snapshot))
+	for id, u := range snapshot {
+		m[id] = u
+	}
+	ft.mtx.Lock()
+	ft.m = m
+	ft.mtx.Unlock()
+}
+
+func (ft *FollowerTable) Get(openid string) (u trader.UserInfo, ok bool) {
+	ft.mtx.RLock()
+	defer ft.mtx.RUnlock()
+	u, ok = ft.m[openid]
+	return
+}
+
+func (ft *FollowerTable) Len() int {
+	ft.mtx.RLock()
+	defer ft.mtx.RUnlock()
+	return len(ft.m)
+}
+
+// 当前表的副本，可作为下一次增量同步的prev
+func (ft *FollowerTable) Snapshot() trader.FollowerSnapshot {
+	ft.mtx.RLock()
+	defer ft.mtx.RUnlock()
+	s := make(trader.FollowerSnapshot, len(ft.m))
+	for id, u := range ft.m {
+		s[id] = u
+	}
+	return s
+}
+
+func (ft *FollowerTable) set(change trader.FollowerChange, u trader.UserInfo) {
+	ft.mtx.Lock()
+	if change == trader.FollowerUnsubscribed {
+		delete(ft.m, u.Openid)
+	} else {
+		ft.m[u.Openid] = u
+	}
+	ft.mtx.Unlock()
+
+	if ft.OnChange != nil {
+		ft.OnChange(trader.FollowerRecord{Change: change, UserInfo: u})
+	}
+}
+
+// 中间件 记录关注与取消关注事件后继续交给后续处理器 用法: w.Use(table.Middleware())
+func (ft *FollowerTable) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) error {
+			r := c.Request()
+			switch r.MsgType() {
+			case SubscribeEventType, ScanSubscribeEventType:
+				u, ok := ft.Get(r.FromUserName())
+				u.Openid, u.Subscribe = r.FromUserName(), 1
+				u.SubscribeTime = int64(r.CreateTime())
+				change := trader.FollowerSubscribed
+				if ok {
+					change = trader.FollowerChanged
+				}
+				ft.set(change, u)
+				if ft.FetchInfo {
+					go ft.fetch(r.FromUserName())
+				}
+			case UnsubscribeEventType:
+				u, _ := ft.Get(r.FromUserName())
+				u.Openid, u.Subscribe = r.FromUserName(), 0
+				ft.set(trader.FollowerUnsubscribed, u)
+			}
+			return next(c)
+		}
+	}
+}
+
+func (ft *FollowerTable) fetch(openid string) {
+	u, err := ft.w.Trader().GetUserInfo(openid)
+	if err != nil || u.Subscribe == 0 {
+		return
+	}
+	ft.set(trader.FollowerChanged, u)
+}
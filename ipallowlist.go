@@ -0,0 +1,251 @@
+package wechat
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slrem/wechat/metrics"
+)
+
+var EmptyIPListError = errors.New("empty callback ip list")
+
+// 微信回调来源IP白名单 定期从getcallbackip刷新
+/*
+	al := wechat.NewIPAllowlist(w)
+	al.SetTrustedProxies("10.0.0.0/8")
+	if err := al.Refresh(); err != nil { ... }
+	al.Start(time.Hour)
+	w.SetIPAllowlist(al)
+*/
+type IPAllowlist struct {
+	w *Wechat
+
+	mtx     sync.RWMutex
+	nets    []*net.IPNet
+	extra   []*net.IPNet
+	proxies []*net.IPNet
+	updated time.Time
+
+	// 可信代理传递客户端地址的请求头，默认X-Forwarded-For，仅当直连地址为可信代理时读取
+	ProxyHeader string
+	// 白名单为空(尚未成功刷新)时放行，默认拒绝
+	AllowWhenEmpty bool
+	// 拒绝请求时调用
+	OnReject func(r *http.Request, ip net.IP)
+	// 定时刷新失败时调用，继续使用之前的白名单
+	OnRefreshError func(err error)
+
+	stop chan struct{}
+}
+
+func NewIPAllowlist(w *Wechat) *IPAllowlist {
+	return &IPAllowlist{w: w, ProxyHeader: "X-Forwarded-For"}
+}
+
+// 将单个IP或CIDR网段解析为网段
+func parseIPNets(list []string) (nets []*net.IPNet, err error) {
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("invalid ip: " + s)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, e := net.ParseCIDR(s)
+		if e != nil {
+			return nil, e
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 设置可信代理的IP或网段
+func (a *IPAllowlist) SetTrustedProxies(list ...string) error {
+	nets, err := parseIPNets(list)
+	if err != nil {
+		return err
+	}
+	a.mtx.Lock()
+	a.proxies = nets
+	a.mtx.Unlock()
+	return nil
+}
+
+// 额外允许的IP或网段，如本地调试地址
+func (a *IPAllowlist) SetExtra(list ...string) error {
+	nets, err := parseIPNets(list)
+	if err != nil {
+		return err
+	}
+	a.mtx.Lock()
+	a.extra = nets
+	a.mtx.Unlock()
+	return nil
+}
+
+// 从微信重新获取回调IP 失败时保留原有白名单
+func (a *IPAllowlist) Refresh() error {
+	list, err := a.w.Trader().GetCallbackIP()
+	if err != nil {
+		return err
+	}
+	nets, err := parseIPNets(list)
+	if err != nil {
+		return err
+	}
+	if len(nets) == 0 {
+		return EmptyIPListError
+	}
+	a.mtx.Lock()
+	a.nets, a.updated = nets, time.Now()
+	a.mtx.Unlock()
+	return nil
+}
+
+// 最近一次成功刷新的时间
+func (a *IPAllowlist) Updated() time.Time {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return a.updated
+}
+
+func (a *IPAllowlist) Contains(ip net.IP) bool {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	if ip == nil {
+		return false
+	}
+	if len(a.nets) == 0 && a.AllowWhenEmpty {
+		return true
+	}
+	return containsIP(a.nets, ip) || containsIP(a.extra, ip)
+}
+
+// 请求的客户端地址 直连地址为可信代理时，从ProxyHeader中由右向左取第一个非可信代理的地址
+func (a *IPAllowlist) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	a.mtx.RLock()
+	proxies := a.proxies
+	a.mtx.RUnlock()
+	if ip == nil || a.ProxyHeader == "" || !containsIP(proxies, ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values(a.ProxyHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := net.ParseIP(strings.TrimSpace(hops[i]))
+		if h == nil {
+			break
+		}
+		ip = h
+		if !containsIP(proxies, h) {
+			break
+		}
+	}
+	return ip
+}
+
+// 请求是否来自白名单内的地址
+func (a *IPAllowlist) Allow(r *http.Request) bool {
+	ip := a.ClientIP(r)
+	if a.Contains(ip) {
+		return true
+	}
+	if a.OnReject != nil {
+		a.OnReject(r, ip)
+	}
+	return false
+}
+
+// 拒绝白名单外请求的http中间件
+func (a *IPAllowlist) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Allow(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 每隔interval执行一次Refresh，直到调用Stop
+func (a *IPAllowlist) Start(interval time.Duration) {
+	a.mtx.Lock()
+	if a.stop != nil {
+		a.mtx.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	a.stop = stop
+	a.mtx.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := a.Refresh(); err != nil && a.OnRefreshError != nil {
+					a.OnRefreshError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (a *IPAllowlist) Stop() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+}
+
+// 设置后Server只处理来自白名单内地址的请求，为nil时不检查
+func (w *Wechat) SetIPAllowlist(a *IPAllowlist) {
+	w.ipAllowlist = a
+}
+
+func (w *Wechat) IPAllowlist() *IPAllowlist {
+	return w.ipAllowlist
+}
+
+func (w *Wechat) allowIP(r *http.Request) bool {
+	a := w.ipAllowlist
+	if a == nil || a.Allow(r) {
+		return true
+	}
+	w.Metrics().Inc(metrics.CallbackRejectedTotal, "reason", "ip")
+	return false
+}
@@ -0,0 +1,104 @@
+package wechat
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIPNets(t *testing.T) {
+	nets, err := parseIPNets([]string{" 1.2.3.4 ", "", "10.0.0.0/8", "2001:db8::1", "2001:db8:1::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.2.3.4/32", "10.0.0.0/8", "2001:db8::1/128", "2001:db8:1::/48"}
+	if len(nets) != len(want) {
+		t.Fatalf("got %v", nets)
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("%d: got %s, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"1.2.3", "10.0.0.0/33", "example.com"} {
+		if _, err := parseIPNets([]string{bad}); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestIPAllowlistContains(t *testing.T) {
+	a := NewIPAllowlist(nil)
+	a.nets, _ = parseIPNets([]string{"101.226.103.0/25", "140.207.54.76"})
+	if err := a.SetExtra("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"101.226.103.1", true},
+		{"101.226.103.127", true},
+		{"101.226.103.128", false},
+		{"140.207.54.76", true},
+		{"140.207.54.77", false},
+		{"127.0.0.1", true},
+		{"::ffff:140.207.54.76", true},
+		{"8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := a.Contains(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if a.Contains(nil) {
+		t.Error("Contains(nil) = true")
+	}
+}
+
+func TestIPAllowlistEmpty(t *testing.T) {
+	a := NewIPAllowlist(nil)
+	ip := net.ParseIP("1.2.3.4")
+	if a.Contains(ip) {
+		t.Error("empty list should reject by default")
+	}
+	a.AllowWhenEmpty = true
+	if !a.Contains(ip) {
+		t.Error("AllowWhenEmpty should allow")
+	}
+}
+
+func TestIPAllowlistClientIP(t *testing.T) {
+	a := NewIPAllowlist(nil)
+	if err := a.SetTrustedProxies("10.0.0.0/8", "192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "8.8.8.8:1234", nil, "8.8.8.8"},
+		{"untrusted remote ignores header", "8.8.8.8:1234", []string{"1.1.1.1"}, "8.8.8.8"},
+		{"trusted proxy", "10.0.0.1:80", []string{"101.226.103.5"}, "101.226.103.5"},
+		{"spoofed left entries ignored", "10.0.0.1:80", []string{"1.1.1.1, 101.226.103.5"}, "101.226.103.5"},
+		{"proxy chain", "10.0.0.1:80", []string{"9.9.9.9, 101.226.103.5, 192.168.1.1, 10.2.2.2"}, "101.226.103.5"},
+		{"multiple headers", "10.0.0.1:80", []string{"9.9.9.9", "101.226.103.5"}, "101.226.103.5"},
+		{"invalid hop stops", "10.0.0.1:80", []string{"101.226.103.5, garbage"}, "10.0.0.1"},
+		{"all trusted", "10.0.0.1:80", []string{"10.3.3.3"}, "10.3.3.3"},
+		{"no header", "10.0.0.1:80", nil, "10.0.0.1"},
+		{"remote without port", "8.8.8.8", nil, "8.8.8.8"},
+		{"ipv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := a.ClientIP(r); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
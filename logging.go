@@ -0,0 +1,341 @@
+package wechat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 将CallbackHook包装为中间件 只能看到进入中间件链的回调，解析失败的回调和中间件之后写入的回复需用Hook记录
+func hookMiddleware(hook CallbackHook) Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) error {
+			start := time.Now()
+			err := next(c)
+			hook(c, err, time.Since(start))
+			return err
+		}
+	}
+}
+
+// 用log/slog记录每次回调
+/*
+	rl := wechat.NewRequestLogger(slog.Default())
+	rl.HashOpenId = true
+	w.Use(rl.Middleware())
+
+	// 或在最终回复写出后记录，解析失败的回调也会记录
+	w.OnCallback(rl.Hook())
+*/
+type RequestLogger struct {
+	logger *slog.Logger
+
+	// 记录openid的sha256摘要而非原值
+	HashOpenId bool
+	// 计算摘要时附加的盐
+	HashSalt string
+	// 成功时的日志级别，默认Info；出错时固定为Error
+	Level slog.Level
+}
+
+func NewRequestLogger(logger *slog.Logger) *RequestLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &RequestLogger{logger: logger, Level: slog.LevelInfo}
+}
+
+func (l *RequestLogger) openid(openid string) string {
+	if !l.HashOpenId || openid == "" {
+		return openid
+	}
+	sum := sha256.Sum256([]byte(l.HashSalt + openid))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 中间件 记录处理函数的耗时和返回的错误
+func (l *RequestLogger) Middleware() Middleware {
+	return hookMiddleware(l.Hook())
+}
+
+func (l *RequestLogger) Hook() CallbackHook {
+	return func(c Context, err error, elapsed time.Duration) {
+		r := c.Request()
+		attrs := []slog.Attr{
+			slog.String("msg_type", r.MsgType().String()),
+			slog.String("openid", l.openid(r.FromUserName())),
+			slog.Duration("latency", elapsed),
+			slog.String("reply_type", c.ReplyType()),
+		}
+		if e := r.Event(); e != "" {
+			attrs = append(attrs, slog.String("event", e))
+		}
+		if k := r.EventKey(); k != "" {
+			attrs = append(attrs, slog.String("event_key", k))
+		}
+		level := l.Level
+		if err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		l.logger.LogAttrs(c.HTTPRequest().Context(), level, "wechat callback", attrs...)
+	}
+}
+
+// 一条审计记录
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	OpenId    string    `json:"openid"`
+	MsgType   string    `json:"msg_type"`
+	Request   string    `json:"request"`
+	Reply     string    `json:"reply,omitempty"`
+	ReplyType string    `json:"reply_type,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// 审计记录的存储
+type AuditSink interface {
+	WriteAudit(rec AuditRecord) error
+	// 删除before之前的记录
+	PurgeAudit(before time.Time) error
+}
+
+// 记录每次回调的原始请求和最终回复 解析、解密失败的回调也会记录
+/*
+	sink, _ := wechat.NewDirAuditSink("audit")
+	defer sink.Close()
+	a := wechat.NewAuditor(sink, 90*24*time.Hour)
+	w.Use(a.Middleware())
+
+	// 或在最终回复写出后记录，解析失败的回调也会记录
+	w.OnCallback(a.Hook())
+*/
+type Auditor struct {
+	sink AuditSink
+
+	// 记录保留时长，<=0时不清理
+	Retention time.Duration
+	// 清理的最小间隔，默认1小时
+	PurgeInterval time.Duration
+	// 写入或清理失败时调用，不影响回调的处理
+	OnError func(err error)
+
+	mtx       sync.Mutex
+	lastPurge time.Time
+	purging   bool
+}
+
+func NewAuditor(sink AuditSink, retention time.Duration) *Auditor {
+	return &Auditor{
+		sink:          sink,
+		Retention:     retention,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// 中间件 记录处理函数返回时的请求和回复
+func (a *Auditor) Middleware() Middleware {
+	return hookMiddleware(a.Hook())
+}
+
+func (a *Auditor) Hook() CallbackHook {
+	return func(c Context, err error, elapsed time.Duration) {
+		rec := AuditRecord{
+			Time:      time.Now(),
+			OpenId:    c.Request().FromUserName(),
+			MsgType:   c.Request().MsgType().String(),
+			Request:   string(c.RawRequest()),
+			Reply:     string(c.Reply()),
+			ReplyType: c.ReplyType(),
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		if e := a.sink.WriteAudit(rec); e != nil {
+			a.fail(e)
+		}
+		a.maybePurge(rec.Time)
+	}
+}
+
+func (a *Auditor) fail(err error) {
+	if a.OnError != nil {
+		a.OnError(err)
+	}
+}
+
+func (a *Auditor) maybePurge(now time.Time) {
+	if a.Retention <= 0 {
+		return
+	}
+	a.mtx.Lock()
+	if a.purging || now.Sub(a.lastPurge) < a.PurgeInterval {
+		a.mtx.Unlock()
+		return
+	}
+	a.purging, a.lastPurge = true, now
+	a.mtx.Unlock()
+
+	go func() {
+		if err := a.sink.PurgeAudit(now.Add(-a.Retention)); err != nil {
+			a.fail(err)
+		}
+		a.mtx.Lock()
+		a.purging = false
+		a.mtx.Unlock()
+	}()
+}
+
+var (
+	AuditQueueFullError  = errors.New("audit queue full, record dropped")
+	AuditSinkClosedError = errors.New("audit sink closed")
+)
+
+// 写入队列的容量
+const AuditQueueSize = 4096
+
+// 按天写入dir下JSON Lines文件的审计存储，文件名为 audit-2006-01-02.jsonl
+// WriteAudit只把记录放入队列，由后台goroutine写文件，不阻塞回调的处理
+// 队列满时丢弃新记录并返回AuditQueueFullError，丢弃数用Dropped查看；Close时写完队列中的记录
+type DirAuditSink struct {
+	dir string
+
+	qmtx    sync.RWMutex
+	queue   chan AuditRecord
+	closed  bool
+	done    chan struct{}
+	dropped int64
+
+	mtx  sync.Mutex
+	day  string
+	file *os.File
+	bw   *bufio.Writer
+
+	// 后台写文件失败时调用
+	OnError func(err error)
+}
+
+func NewDirAuditSink(dir string) (*DirAuditSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &DirAuditSink{
+		dir:   dir,
+		queue: make(chan AuditRecord, AuditQueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+const auditFileLayout = "audit-2006-01-02.jsonl"
+
+func (s *DirAuditSink) WriteAudit(rec AuditRecord) error {
+	s.qmtx.RLock()
+	defer s.qmtx.RUnlock()
+	if s.closed {
+		return AuditSinkClosedError
+	}
+	select {
+	case s.queue <- rec:
+		return nil
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		return AuditQueueFullError
+	}
+}
+
+// 队列满被丢弃的记录数
+func (s *DirAuditSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *DirAuditSink) run() {
+	defer close(s.done)
+	for rec := range s.queue {
+		err := s.write(rec, len(s.queue) == 0)
+		if err != nil && s.OnError != nil {
+			s.OnError(err)
+		}
+	}
+}
+
+// 写入一条记录 flush为true时刷新缓冲，队列中还有记录时合并写入
+func (s *DirAuditSink) write(rec AuditRecord, flush bool) (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	day := rec.Time.Format(auditFileLayout)
+	if s.file == nil || s.day != day {
+		s.closeFile()
+		s.file, err = os.OpenFile(filepath.Join(s.dir, day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return
+		}
+		s.day, s.bw = day, bufio.NewWriter(s.file)
+	}
+	enc := json.NewEncoder(s.bw)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(rec)
+	if err != nil || !flush {
+		return
+	}
+	return s.bw.Flush()
+}
+
+func (s *DirAuditSink) PurgeAudit(before time.Time) (err error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "audit-*.jsonl"))
+	if err != nil {
+		return
+	}
+	sort.Strings(names)
+	// 文件中最晚的记录在当天结束前，只删除整天都早于before的文件
+	cutoff := before.Format(auditFileLayout)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, name := range names {
+		base := filepath.Base(name)
+		if base >= cutoff {
+			break
+		}
+		if base == s.day {
+			s.closeFile()
+		}
+		if e := os.Remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (s *DirAuditSink) closeFile() {
+	if s.file != nil {
+		s.bw.Flush()
+		s.file.Close()
+		s.file, s.bw, s.day = nil, nil, ""
+	}
+}
+
+// 停止接收记录，等待队列写完后关闭文件
+func (s *DirAuditSink) Close() error {
+	s.qmtx.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.qmtx.Unlock()
+	<-s.done
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closeFile()
+	return nil
+}
@@ -0,0 +1,171 @@
+package wechat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	rl := NewRequestLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	rl.HashOpenId = true
+	fail := errors.New("boom")
+	tests := []struct {
+		err   error
+		level string
+	}{
+		{nil, "INFO"},
+		{fail, "ERROR"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		h := rl.Middleware()(func(c Context) error {
+			c.Response().Text("hi")
+			return tt.err
+		})
+		c, _ := newTestContext(t, eventXML("CLICK", "V1001"))
+		if err := h(c); err != tt.err {
+			t.Fatalf("middleware returned %v, want %v", err, tt.err)
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%v: %q", err, buf.String())
+		}
+		if line["level"] != tt.level || line["event"] != "CLICK" || line["event_key"] != "V1001" || line["reply_type"] != "text" {
+			t.Errorf("log line %v", line)
+		}
+		if line["openid"] == "u" || line["openid"] == "" {
+			t.Errorf("openid not hashed: %v", line["openid"])
+		}
+		if tt.err != nil && line["error"] != "boom" {
+			t.Errorf("error not logged: %v", line)
+		}
+	}
+}
+
+type memAuditSink struct {
+	mtx  sync.Mutex
+	recs []AuditRecord
+}
+
+func (s *memAuditSink) WriteAudit(rec AuditRecord) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.recs = append(s.recs, rec)
+	return nil
+}
+
+func (s *memAuditSink) PurgeAudit(before time.Time) error {
+	return nil
+}
+
+func TestAuditorMiddleware(t *testing.T) {
+	sink := &memAuditSink{}
+	h := NewAuditor(sink, 0).Middleware()(func(c Context) error {
+		return c.Response().Text("reply")
+	})
+	xml := textXML("hello")
+	c, _ := newTestContext(t, xml)
+	c.raw = []byte(xml)
+	if err := h(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.recs) != 1 {
+		t.Fatalf("%d records", len(sink.recs))
+	}
+	rec := sink.recs[0]
+	if rec.OpenId != "u" || rec.MsgType != "text" || rec.Request != xml || rec.ReplyType != "text" || !bytes.Contains([]byte(rec.Reply), []byte("reply")) {
+		t.Errorf("record %+v", rec)
+	}
+}
+
+func readAuditLines(t *testing.T, dir string) (n int) {
+	t.Helper()
+	names, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var rec AuditRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		f.Close()
+	}
+	return
+}
+
+func TestDirAuditSink(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDirAuditSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 5, 1, 23, 59, 0, 0, time.Local)
+	for i := 0; i < 10; i++ {
+		if err := s.WriteAudit(AuditRecord{Time: day.Add(time.Duration(i) * 30 * time.Second), Request: "<xml/>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := readAuditLines(t, dir); n != 10 {
+		t.Errorf("%d records written, want 10", n)
+	}
+	for _, name := range []string{"audit-2024-05-01.jsonl", "audit-2024-05-02.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := s.WriteAudit(AuditRecord{Time: day}); err != AuditSinkClosedError {
+		t.Errorf("write after close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestDirAuditSinkQueueFull(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDirAuditSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 阻塞后台写入，填满队列
+	s.mtx.Lock()
+	accepted, dropped := 0, 0
+	for i := 0; i < AuditQueueSize+10; i++ {
+		switch err := s.WriteAudit(AuditRecord{Time: time.Now()}); err {
+		case nil:
+			accepted++
+		case AuditQueueFullError:
+			dropped++
+		default:
+			t.Fatal(err)
+		}
+	}
+	s.mtx.Unlock()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dropped == 0 || int64(dropped) != s.Dropped() {
+		t.Errorf("dropped %d, Dropped() = %d", dropped, s.Dropped())
+	}
+	if n := readAuditLines(t, dir); n != accepted {
+		t.Errorf("%d records written, %d accepted", n, accepted)
+	}
+}
@@ -0,0 +1,244 @@
+package wechat
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slrem/wechat/trader"
+)
+
+const (
+	MassJobSending = "SENDING"
+	MassJobSuccess = "SEND_SUCCESS"
+	MassJobFail    = "SEND_FAIL"
+	MassJobDelete  = "DELETE"
+)
+
+// 一次群发任务的状态，由MASSSENDJOBFINISH事件或轮询mass/get更新
+type MassJob struct {
+	MsgId      int
+	MsgDataId  int
+	Status     string
+	RawStatus  string //事件中的原始Status，如 "send success"、"err(10001)"
+	CreateTime time.Time
+	FinishTime time.Time
+
+	TotalCount     int
+	FilterCount    int
+	SentCount      int
+	ErrorCount     int
+	CopyrightCheck CopyrightCheckResult
+	ArticleUrls    []ArticleUrlItem
+}
+
+func (j MassJob) Finished() bool {
+	return j.Status != MassJobSending
+}
+
+// 是否因被判定为转载而不能群发
+func (j MassJob) CopyrightFailed() bool {
+	return j.CopyrightCheck.CheckState == 3 || j.RawStatus == "err(20013)"
+}
+
+type MassJobTracker struct {
+	w    *Wechat
+	mtx  sync.Mutex
+	jobs map[int]*MassJob
+
+	// 超过该时间仍未收到事件的任务改为轮询mass/get获取状态，默认10分钟
+	PollAfter time.Duration
+	// 任务完成(成功、失败或删除)时调用
+	OnFinish func(job MassJob)
+	// 原创校验未通过时调用，先于OnFinish
+	OnCopyrightFail func(job MassJob)
+
+	stop chan struct{}
+}
+
+func NewMassJobTracker(w *Wechat) *MassJobTracker {
+	return &MassJobTracker{
+		w:         w,
+		jobs:      make(map[int]*MassJob),
+		PollAfter: 10 * time.Minute,
+	}
+}
+
+// 记录一次群发，s为群发接口的返回
+func (mt *MassJobTracker) Track(s trader.SendAllResp) {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	if _, ok := mt.jobs[s.MsgId]; ok {
+		return
+	}
+	mt.jobs[s.MsgId] = &MassJob{
+		MsgId:      s.MsgId,
+		MsgDataId:  s.MsgDataId,
+		Status:     MassJobSending,
+		CreateTime: time.Now(),
+	}
+}
+
+// 根据标签群发并记录
+func (mt *MassJobTracker) SendAll(tagId int, msg trader.MassMessage, opt trader.SendAllOptions) (s trader.SendAllResp, err error) {
+	s, err = mt.w.Trader().SendAll(tagId, msg, opt)
+	if err != nil {
+		return
+	}
+	mt.Track(s)
+	return
+}
+
+// 根据OpenID列表群发并记录，拆分后的每次群发分别记录
+func (mt *MassJobTracker) SendByOpenIds(openids []string, msg trader.MassMessage, opt trader.SendAllOptions) (list []trader.SendAllResp, err error) {
+	list, err = mt.w.Trader().SendByOpenIds(openids, msg, opt)
+	for _, s := range list {
+		mt.Track(s)
+	}
+	return
+}
+
+func (mt *MassJobTracker) Job(msgid int) (job MassJob, ok bool) {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	j, ok := mt.jobs[msgid]
+	if ok {
+		job = *j
+	}
+	return
+}
+
+func (mt *MassJobTracker) Jobs() (jobs []MassJob) {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	for _, j := range mt.jobs {
+		jobs = append(jobs, *j)
+	}
+	return
+}
+
+// 删除已完成且早于before的任务记录
+func (mt *MassJobTracker) Prune(before time.Time) {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	for id, j := range mt.jobs {
+		if j.Finished() && j.CreateTime.Before(before) {
+			delete(mt.jobs, id)
+		}
+	}
+}
+
+// MASSSENDJOBFINISH事件处理 用法: w.MassSendJobFinishEvent(tracker.Handler())
+func (mt *MassJobTracker) Handler() Handler {
+	return func(c Context) error {
+		r := c.Request()
+		id := int(r.MassMsgId())
+
+		mt.mtx.Lock()
+		j, ok := mt.jobs[id]
+		if !ok {
+			j = &MassJob{MsgId: id, CreateTime: time.Unix(int64(r.CreateTime()), 0)}
+			mt.jobs[id] = j
+		}
+		// 微信重试推送或已通过轮询完成的任务只更新数据，不重复回调
+		notify := !j.Finished() || !ok
+		j.RawStatus = r.Status()
+		j.Status = massEventStatus(r.Status())
+		j.FinishTime = time.Now()
+		j.TotalCount, j.FilterCount = r.TotalCount(), r.FilterCount()
+		j.SentCount, j.ErrorCount = r.SentCount(), r.ErrorCount()
+		j.CopyrightCheck, j.ArticleUrls = r.CopyrightCheckResult(), r.ArticleUrlResult()
+		job := *j
+		mt.mtx.Unlock()
+
+		if notify {
+			mt.finish(job)
+		}
+		return c.Response().Success()
+	}
+}
+
+func massEventStatus(status string) string {
+	if strings.Replace(strings.ToLower(status), " ", "", -1) == "sendsuccess" {
+		return MassJobSuccess
+	}
+	return MassJobFail
+}
+
+func (mt *MassJobTracker) finish(job MassJob) {
+	if job.CopyrightFailed() && mt.OnCopyrightFail != nil {
+		mt.OnCopyrightFail(job)
+	}
+	if mt.OnFinish != nil {
+		mt.OnFinish(job)
+	}
+}
+
+// 轮询超过PollAfter仍未完成的任务
+func (mt *MassJobTracker) Poll() (err error) {
+	var pending []int
+	mt.mtx.Lock()
+	for id, j := range mt.jobs {
+		if !j.Finished() && time.Since(j.CreateTime) >= mt.PollAfter {
+			pending = append(pending, id)
+		}
+	}
+	mt.mtx.Unlock()
+
+	for _, id := range pending {
+		status, e := mt.w.Trader().GetSendAllStatus(id)
+		if e != nil {
+			err = e
+			continue
+		}
+		if status == MassJobSending {
+			continue
+		}
+		mt.mtx.Lock()
+		j, ok := mt.jobs[id]
+		if !ok || j.Finished() {
+			mt.mtx.Unlock()
+			continue
+		}
+		j.Status, j.RawStatus, j.FinishTime = status, status, time.Now()
+		job := *j
+		mt.mtx.Unlock()
+
+		mt.finish(job)
+	}
+	return
+}
+
+// 每隔interval执行一次Poll，直到调用Stop
+func (mt *MassJobTracker) Start(interval time.Duration) {
+	mt.mtx.Lock()
+	if mt.stop != nil {
+		mt.mtx.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	mt.stop = stop
+	mt.mtx.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				mt.Poll()
+			}
+		}
+	}()
+}
+
+func (mt *MassJobTracker) Stop() {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	if mt.stop != nil {
+		close(mt.stop)
+		mt.stop = nil
+	}
+}
@@ -0,0 +1,43 @@
+// Package metrics 定义回调和接口调用的指标收集接口
+//
+// 默认使用Nop不做任何收集，需要时用NewPrometheus得到可直接挂到http.ServeMux上的
+// Prometheus文本格式实现，或自行实现Metrics接入其他系统
+package metrics
+
+// 指标名称
+const (
+	CallbacksTotal        = "wechat_callbacks_total"           // 回调次数 msg_type
+	CallbackDuration      = "wechat_callback_duration_seconds" // 回调处理耗时 msg_type
+	CallbackRepliesTotal  = "wechat_callback_replies_total"    // 回复结果 reply_type, result
+	CallbackRejectedTotal = "wechat_callback_rejected_total"   // 被拒绝的回调 reason: ip、signature、read、decrypt、parse
+	APIRequestsTotal      = "wechat_api_requests_total"        // 接口调用次数 endpoint, errcode
+	APIDuration           = "wechat_api_duration_seconds"      // 接口调用耗时 endpoint
+	TokenRefreshTotal     = "wechat_token_refresh_total"       // access_token刷新次数 result
+	APIQuotaRemaining     = "wechat_api_quota_remaining"       // 接口当日剩余调用次数 endpoint
+	APIQuotaDailyLimit    = "wechat_api_quota_daily_limit"     // 接口每日调用上限 endpoint
+)
+
+// 指标收集接口 labels为成对的标签名和值
+type Metrics interface {
+	// 计数器加1
+	Inc(name string, labels ...string)
+	// 直方图记录一次观测值
+	Observe(name string, value float64, labels ...string)
+	// 设置仪表盘的值
+	Set(name string, value float64, labels ...string)
+}
+
+// 不做任何收集的Metrics
+type Nop struct{}
+
+func (Nop) Inc(name string, labels ...string)                    {}
+func (Nop) Observe(name string, value float64, labels ...string) {}
+func (Nop) Set(name string, value float64, labels ...string)     {}
+
+// m为nil时返回Nop
+func OrNop(m Metrics) Metrics {
+	if m == nil {
+		return Nop{}
+	}
+	return m
+}
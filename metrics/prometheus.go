@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 默认的直方图分桶(秒)
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+type series struct {
+	labels  string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type family struct {
+	typ    string
+	series map[string]*series
+}
+
+// 以Prometheus文本格式输出指标的Metrics
+/*
+	m := metrics.NewPrometheus()
+	w.SetMetrics(m)
+	http.Handle("/metrics", m)
+*/
+type Prometheus struct {
+	mtx      sync.Mutex
+	families map[string]*family
+	help     map[string]string
+
+	// 直方图分桶，需在开始收集前设置
+	Buckets []float64
+}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		families: make(map[string]*family),
+		help:     make(map[string]string),
+		Buckets:  DefaultBuckets,
+	}
+}
+
+// 设置指标的HELP说明
+func (p *Prometheus) Help(name, text string) {
+	p.mtx.Lock()
+	p.help[name] = text
+	p.mtx.Unlock()
+}
+
+// 查找或创建序列 类型与已有的不一致时返回nil
+func (p *Prometheus) get(typ, name string, labels []string) *series {
+	f, ok := p.families[name]
+	if !ok {
+		f = &family{typ: typ, series: make(map[string]*series)}
+		p.families[name] = f
+	}
+	if f.typ != typ {
+		return nil
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: key}
+		if typ == histogramType {
+			s.buckets = make([]uint64, len(p.Buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (p *Prometheus) Inc(name string, labels ...string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if s := p.get(counterType, name, labels); s != nil {
+		s.value++
+	}
+}
+
+func (p *Prometheus) Observe(name string, value float64, labels ...string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	s := p.get(histogramType, name, labels)
+	if s == nil {
+		return
+	}
+	for i, b := range p.Buckets {
+		if i < len(s.buckets) && value <= b {
+			s.buckets[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (p *Prometheus) Set(name string, value float64, labels ...string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if s := p.get(gaugeType, name, labels); s != nil {
+		s.value = value
+	}
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	p.write(bw)
+	bw.Flush()
+}
+
+func (p *Prometheus) write(w *bufio.Writer) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		if h, ok := p.help[name]; ok {
+			w.WriteString("# HELP " + name + " " + escapeHelp(h) + "\n")
+		}
+		w.WriteString("# TYPE " + name + " " + f.typ + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.typ != histogramType {
+				writeSample(w, name, s.labels, "", s.value)
+				continue
+			}
+			for i, b := range p.Buckets {
+				if i < len(s.buckets) {
+					writeSample(w, name+"_bucket", s.labels, `le="`+formatFloat(b)+`"`, float64(s.buckets[i]))
+				}
+			}
+			writeSample(w, name+"_bucket", s.labels, `le="+Inf"`, float64(s.count))
+			writeSample(w, name+"_sum", s.labels, "", s.value)
+			writeSample(w, name+"_count", s.labels, "", float64(s.count))
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels, extra string, v float64) {
+	w.WriteString(name)
+	if labels != "" || extra != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		if labels != "" && extra != "" {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// 将成对的标签格式化为 k="v",k2="v2" 多余的单个标签名被忽略
+func formatLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
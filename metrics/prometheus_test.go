@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(p *Prometheus) string {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestPrometheusCounterGauge(t *testing.T) {
+	p := NewPrometheus()
+	p.Help(CallbacksTotal, "callbacks\nreceived \\ total")
+	p.Inc(CallbacksTotal, "msg_type", "text")
+	p.Inc(CallbacksTotal, "msg_type", "text")
+	p.Inc(CallbacksTotal, "msg_type", "event")
+	p.Set(APIQuotaRemaining, 42, "endpoint", "/cgi-bin/message/custom/send")
+	p.Set(APIQuotaRemaining, 41, "endpoint", "/cgi-bin/message/custom/send")
+
+	want := `# TYPE wechat_api_quota_remaining gauge
+wechat_api_quota_remaining{endpoint="/cgi-bin/message/custom/send"} 41
+# HELP wechat_callbacks_total callbacks\nreceived \\ total
+# TYPE wechat_callbacks_total counter
+wechat_callbacks_total{msg_type="event"} 1
+wechat_callbacks_total{msg_type="text"} 2
+`
+	if got := scrape(p); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrometheusHistogram(t *testing.T) {
+	p := NewPrometheus()
+	p.Buckets = []float64{0.1, 1}
+	p.Observe(APIDuration, 0.05, "endpoint", "/a")
+	p.Observe(APIDuration, 0.5, "endpoint", "/a")
+	p.Observe(APIDuration, 2, "endpoint", "/a")
+
+	want := `# TYPE wechat_api_duration_seconds histogram
+wechat_api_duration_seconds_bucket{endpoint="/a",le="0.1"} 1
+wechat_api_duration_seconds_bucket{endpoint="/a",le="1"} 2
+wechat_api_duration_seconds_bucket{endpoint="/a",le="+Inf"} 3
+wechat_api_duration_seconds_sum{endpoint="/a"} 2.55
+wechat_api_duration_seconds_count{endpoint="/a"} 3
+`
+	if got := scrape(p); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrometheusTypeConflict(t *testing.T) {
+	p := NewPrometheus()
+	p.Inc("m")
+	p.Set("m", 5)
+	p.Observe("m", 1)
+	if got, want := scrape(p), "# TYPE m counter\nm 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		labels []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"a"}, ""},
+		{[]string{"a", "1"}, `a="1"`},
+		{[]string{"a", "1", "b", "2", "c"}, `a="1",b="2"`},
+		{[]string{"a", `x"y\z` + "\n"}, `a="x\"y\\z\n"`},
+	}
+	for _, tt := range tests {
+		if got := formatLabels(tt.labels); got != tt.want {
+			t.Errorf("formatLabels(%q) = %s, want %s", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestPrometheusContentType(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewPrometheus().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+}
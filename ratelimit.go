@@ -0,0 +1,259 @@
+package wechat
+
+import (
+	"sync"
+	"time"
+)
+
+// 限流状态的存储 多副本部署时实现为共享存储(如Redis)以共用限额
+type RateLimitStore interface {
+	// 从key的令牌桶取一个令牌 桶每秒补充rate个，最多burst个
+	Allow(key string, rate float64, burst int, now time.Time) (bool, error)
+	// 封禁key直到until
+	Ban(key string, until time.Time) error
+	// key的封禁截止时间，未封禁时返回零值
+	BannedUntil(key string, now time.Time) (time.Time, error)
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 进程内的RateLimitStore
+type MemoryRateLimitStore struct {
+	mtx     sync.Mutex
+	buckets map[string]*rateBucket
+	bans    map[string]time.Time
+	ops     int
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*rateBucket),
+		bans:    make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryRateLimitStore) Allow(key string, rate float64, burst int, now time.Time) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sweep(rate, burst, now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+func (s *MemoryRateLimitStore) Ban(key string, until time.Time) error {
+	s.mtx.Lock()
+	s.bans[key] = until
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *MemoryRateLimitStore) BannedUntil(key string, now time.Time) (time.Time, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	until, ok := s.bans[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	if !now.Before(until) {
+		delete(s.bans, key)
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// 每1000次操作清理一次已补满的令牌桶和过期的封禁
+func (s *MemoryRateLimitStore) sweep(rate float64, burst int, now time.Time) {
+	s.ops++
+	if s.ops < 1000 {
+		return
+	}
+	s.ops = 0
+	for k, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(s.buckets, k)
+		}
+	}
+	for k, until := range s.bans {
+		if !now.Before(until) {
+			delete(s.bans, k)
+		}
+	}
+}
+
+// 超出限额时的处理方式
+type RateLimitAction int
+
+const (
+	RateLimitSilent RateLimitAction = iota // 回复success，用户收不到任何回复
+	RateLimitText                          // 回复Text
+	RateLimitBan                           // 封禁BanDuration，封禁期间的消息均回复success
+)
+
+// 按FromUserName限流的中间件
+// 微信重试推送的消息不消耗令牌，沿用第一次的结果：放行过的继续放行，被限流的仍然限流
+/*
+	rl := wechat.NewUserRateLimiter(nil, 0.2, 5) // 每5秒1条，最多连续5条
+	rl.Action = wechat.RateLimitText
+	rl.Text = "消息太频繁了，请稍后再试"
+	w.Use(rl.Middleware())
+*/
+type UserRateLimiter struct {
+	store RateLimitStore
+
+	mtx     sync.Mutex
+	resends *recentKeys
+
+	// 每秒补充的令牌数，<=0时不限流
+	Rate float64
+	// 令牌桶容量，即允许连续发送的条数，<1时按1处理
+	Burst int
+	// 超出限额时的处理方式，默认RateLimitSilent
+	Action RateLimitAction
+	// RateLimitText时的回复，RateLimitBan时不为空则在封禁时回复一次
+	Text string
+	// RateLimitBan时的封禁时长，默认10分钟
+	BanDuration time.Duration
+	// 需要限流的消息，默认只限制非事件消息
+	Filter func(r Request) bool
+	// 存储出错时拒绝请求，默认放行
+	FailClosed bool
+	// 超出限额时调用
+	OnLimit func(openid string, banned bool)
+	// 存储出错时调用
+	OnError func(err error)
+}
+
+// store为nil时使用MemoryRateLimitStore
+func NewUserRateLimiter(store RateLimitStore, rate float64, burst int) *UserRateLimiter {
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &UserRateLimiter{
+		store:       store,
+		resends:     newRecentKeys(),
+		Rate:        rate,
+		Burst:       burst,
+		BanDuration: 10 * time.Minute,
+	}
+}
+
+func (l *UserRateLimiter) filter(r Request) bool {
+	if l.Filter != nil {
+		return l.Filter(r)
+	}
+	return r.Event() == ""
+}
+
+func (l *UserRateLimiter) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// 重试推送的消息上一次是否放行
+func (l *UserRateLimiter) resend(key string) (allowed, ok bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.resends.get(key)
+}
+
+func (l *UserRateLimiter) remember(key string, allowed bool) {
+	l.mtx.Lock()
+	l.resends.put(key, allowed, resendKeysSize)
+	l.mtx.Unlock()
+}
+
+func (l *UserRateLimiter) fail(err error) bool {
+	if l.OnError != nil {
+		l.OnError(err)
+	}
+	return l.FailClosed
+}
+
+func (l *UserRateLimiter) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) error {
+			r := c.Request()
+			openid := r.FromUserName()
+			if openid == "" || l.Rate <= 0 || !l.filter(r) {
+				return next(c)
+			}
+			key := resendKey(r)
+			if allowed, ok := l.resend(key); ok {
+				if allowed {
+					return next(c)
+				}
+				return l.reply(c)
+			}
+			now := time.Now()
+
+			until, err := l.store.BannedUntil(openid, now)
+			if err != nil && l.fail(err) {
+				return c.Response().Success()
+			}
+			if !until.IsZero() {
+				return c.Response().Success()
+			}
+
+			ok, err := l.store.Allow(openid, l.Rate, l.burst(), now)
+			if err != nil {
+				if l.fail(err) {
+					return c.Response().Success()
+				}
+				ok = true
+			}
+			l.remember(key, ok)
+			if ok {
+				return next(c)
+			}
+			return l.limit(c, openid, now)
+		}
+	}
+}
+
+func (l *UserRateLimiter) limit(c Context, openid string, now time.Time) error {
+	banned := false
+	if l.Action == RateLimitBan {
+		d := l.BanDuration
+		if d <= 0 {
+			d = 10 * time.Minute
+		}
+		if err := l.store.Ban(openid, now.Add(d)); err != nil {
+			l.fail(err)
+		} else {
+			banned = true
+		}
+	}
+	if l.OnLimit != nil {
+		l.OnLimit(openid, banned)
+	}
+	return l.reply(c)
+}
+
+// 被限流时的回复
+func (l *UserRateLimiter) reply(c Context) error {
+	if l.Text != "" && (l.Action == RateLimitText || l.Action == RateLimitBan) {
+		return c.Response().Text(l.Text)
+	}
+	return c.Response().Success()
+}
@@ -0,0 +1,153 @@
+package wechat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreAllow(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	t0 := time.Unix(1700000000, 0)
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		// 初始满桶3个令牌
+		{0, true},
+		{0, true},
+		{0, true},
+		{0, false},
+		// 每秒补充0.5个
+		{time.Second, false},
+		{2 * time.Second, true},
+		{2 * time.Second, false},
+		// 补充不超过容量
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, false},
+	}
+	for i, st := range steps {
+		ok, err := s.Allow("u", 0.5, 3, t0.Add(st.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != st.want {
+			t.Errorf("step %d at %v: got %v, want %v", i, st.at, ok, st.want)
+		}
+	}
+	if ok, _ := s.Allow("other", 0.5, 3, t0); !ok {
+		t.Error("keys should have separate buckets")
+	}
+}
+
+func TestMemoryRateLimitStoreBan(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	t0 := time.Unix(1700000000, 0)
+	until := t0.Add(time.Minute)
+	if err := s.Ban("u", until); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at   time.Time
+		want time.Time
+	}{
+		{t0, until},
+		{until.Add(-time.Nanosecond), until},
+		{until, time.Time{}},
+		{t0, time.Time{}}, // 过期后已删除
+	}
+	for i, tt := range tests {
+		got, err := s.BannedUntil("u", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+	if got, _ := s.BannedUntil("nobody", t0); !got.IsZero() {
+		t.Errorf("unbanned key: got %v", got)
+	}
+}
+
+func msgXML(openid string, msgid int) string {
+	return fmt.Sprintf(`<xml><ToUserName>gh</ToUserName><FromUserName>%s</FromUserName><CreateTime>%d</CreateTime><MsgType>text</MsgType><Content>hi</Content><MsgId>%d</MsgId></xml>`, openid, msgid, msgid)
+}
+
+// 依次发送消息，返回每条是否交给了后续处理器及其回复
+func runLimiter(t *testing.T, l *UserRateLimiter, xmls ...string) (passed []bool, replies []string) {
+	t.Helper()
+	h := l.Middleware()(func(c Context) error {
+		return c.Response().Text("handled")
+	})
+	for _, x := range xmls {
+		c, rec := newTestContext(t, x)
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		passed = append(passed, c.ReplyType() == "text" && strings.Contains(rec.Body.String(), "handled"))
+		replies = append(replies, rec.Body.String())
+	}
+	return
+}
+
+func TestUserRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		xmls  []string
+		want  []bool
+	}{
+		{"burst", 0.001, 2, []string{msgXML("u", 1), msgXML("u", 2), msgXML("u", 3)}, []bool{true, true, false}},
+		{"zero burst allows one", 0.001, 0, []string{msgXML("u", 1), msgXML("u", 2)}, []bool{true, false}},
+		{"per user", 0.001, 1, []string{msgXML("a", 1), msgXML("b", 2), msgXML("a", 3)}, []bool{true, true, false}},
+		{"disabled", 0, 1, []string{msgXML("u", 1), msgXML("u", 2), msgXML("u", 3)}, []bool{true, true, true}},
+		{"events not limited", 0.001, 1, []string{msgXML("u", 1), eventXML("CLICK", "k"), eventXML("CLICK", "k")}, []bool{true, true, true}},
+		{"resend of allowed message", 0.001, 1, []string{msgXML("u", 1), msgXML("u", 1), msgXML("u", 1), msgXML("u", 2)}, []bool{true, true, true, false}},
+		{"resend of limited message", 0.001, 1, []string{msgXML("u", 1), msgXML("u", 2), msgXML("u", 2)}, []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		l := NewUserRateLimiter(nil, tt.rate, tt.burst)
+		l.Burst = tt.burst
+		l.Filter = func(r Request) bool { return r.MsgType() == TextType }
+		got, _ := runLimiter(t, l, tt.xmls...)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: passed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserRateLimiterResendCountsOnce(t *testing.T) {
+	l := NewUserRateLimiter(nil, 0.001, 1)
+	l.Action = RateLimitText
+	l.Text = "slow down"
+	limits := 0
+	l.OnLimit = func(openid string, banned bool) { limits++ }
+	_, replies := runLimiter(t, l, msgXML("u", 1), msgXML("u", 2), msgXML("u", 2), msgXML("u", 2))
+	if limits != 1 {
+		t.Errorf("OnLimit called %d times, want 1", limits)
+	}
+	for _, r := range replies[1:] {
+		if !strings.Contains(r, "slow down") {
+			t.Errorf("limited reply %q", r)
+		}
+	}
+}
+
+func TestUserRateLimiterBan(t *testing.T) {
+	l := NewUserRateLimiter(nil, 0.001, 1)
+	l.Action = RateLimitBan
+	banned := false
+	l.OnLimit = func(openid string, b bool) { banned = b }
+	got, replies := runLimiter(t, l, msgXML("u", 1), msgXML("u", 2), msgXML("u", 3))
+	if fmt.Sprint(got) != "[true false false]" || !banned {
+		t.Fatalf("passed %v, banned %v", got, banned)
+	}
+	if replies[2] != "success" {
+		t.Errorf("banned reply %q", replies[2])
+	}
+}
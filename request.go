@@ -29,6 +29,15 @@ const (
 	picWeixinEventValue                 = "pic_weixin"
 	locationSelectEventValue            = "location_select"
 	templateSendJobFinishEventTypeValue = "TEMPLATESENDJOBFINISH"
+	massSendJobFinishEventValue         = "MASSSENDJOBFINISH"
+
+	kfCreateSessionEventValue = "kf_create_session"
+	kfCloseSessionEventValue  = "kf_close_session"
+	kfSwitchSessionEventValue = "kf_switch_session"
+
+	subscribeMsgPopupEventValue  = "subscribe_msg_popup_event"
+	subscribeMsgChangeEventValue = "subscribe_msg_change_event"
+	subscribeMsgSentEventValue   = "subscribe_msg_sent_event"
 )
 
 type MsgType int
@@ -61,8 +70,58 @@ const (
 	LocationSelectEvenType
 
 	TemplateSendJobFinishEventType
+
+	SubscribeMsgPopupEventType
+	SubscribeMsgChangeEventType
+	SubscribeMsgSentEventType
+
+	MassSendJobFinishEventType
+
+	KfCreateSessionEventType
+	KfCloseSessionEventType
+	KfSwitchSessionEventType
 )
 
+var msgTypeNames = map[MsgType]string{
+	TextType:                       textValue,
+	ImageType:                      imageValue,
+	VoiceType:                      voiceValue,
+	VideoType:                      videoValue,
+	ShortVideoType:                 shortVideoValue,
+	LocationType:                   locationValue,
+	LinkType:                       linkValue,
+	EventType:                      eventValue,
+	SubscribeEventType:             subscribeEventValue,
+	UnsubscribeEventType:           unsubscribeEventValue,
+	ScanEventType:                  scanEventValue,
+	ScanSubscribeEventType:         "subscribe_qrscene",
+	LocationEventType:              locationEventValue,
+	MenuViewEventType:              viewEventValue,
+	MenuClickEventType:             clickEventValue,
+	ScancodePushEventType:          scancodePushEventValue,
+	ScancodeWaitmsgEventType:       scancodeWaitmsgEventValue,
+	PicSysphotoEventType:           picSysphotoEventValue,
+	PicPhotoOrAlbumEventType:       picPhotoOrAlbumEventValue,
+	PicWeixinEventType:             picWeixinEventValue,
+	LocationSelectEvenType:         locationSelectEventValue,
+	TemplateSendJobFinishEventType: templateSendJobFinishEventTypeValue,
+	SubscribeMsgPopupEventType:     subscribeMsgPopupEventValue,
+	SubscribeMsgChangeEventType:    subscribeMsgChangeEventValue,
+	SubscribeMsgSentEventType:      subscribeMsgSentEventValue,
+	MassSendJobFinishEventType:     massSendJobFinishEventValue,
+	KfCreateSessionEventType:       kfCreateSessionEventValue,
+	KfCloseSessionEventType:        kfCloseSessionEventValue,
+	KfSwitchSessionEventType:       kfSwitchSessionEventValue,
+}
+
+// 消息类型名称 普通消息为MsgType，事件为Event的值，扫码关注为subscribe_qrscene
+func (t MsgType) String() string {
+	if s, ok := msgTypeNames[t]; ok {
+		return s
+	}
+	return "unknown"
+}
+
 type ScanCodeInfo struct {
 	ScanType   string
 	ScanResult string
@@ -89,12 +148,47 @@ type SendLocationInfo struct {
 	Poiname    string
 }
 
+// 群发图文的原创校验结果
+type CopyrightCheckResult struct {
+	Count      int
+	ResultList []CopyrightCheckItem `xml:"ResultList>item"`
+	CheckState int                  //1-未被判为转载，可以群发 2-被判为转载，可以群发 3-被判为转载，不能群发
+}
+
+type CopyrightCheckItem struct {
+	ArticleIdx            int
+	UserDeclareState      int
+	AuditState            int
+	OriginalArticleUrl    string
+	OriginalArticleType   int
+	CanReprint            int
+	NeedReplaceContent    int
+	NeedShowReprintSource int
+}
+
+// 群发图文各篇文章的url
+type ArticleUrlItem struct {
+	ArticleIdx int
+	ArticleUrl string
+}
+
+// 订阅消息事件中的一项，不同事件只填充各自相关的字段
+type SubscribeMsgItem struct {
+	TemplateId            string
+	SubscribeStatusString string
+	PopupScene            int
+	MsgID                 int64
+	ErrorCode             int
+	ErrorStatus           string
+}
+
 type requestMessage struct {
 	ToUserName   string
 	FromUserName string
 	CreateTime   int
 	MsgType      string
 	Content      string
+	BizMsgMenuId string `xml:"bizmsgmenuid"`
 	MsgId        int64
 	PicUrl       string
 	MediaId      string
@@ -121,6 +215,22 @@ type requestMessage struct {
 	SendLocationInfo SendLocationInfo
 
 	Status string
+
+	MsgID                int64
+	TotalCount           int
+	FilterCount          int
+	SentCount            int
+	ErrorCount           int
+	CopyrightCheckResult CopyrightCheckResult
+	ArticleUrlResult     []ArticleUrlItem `xml:"ArticleUrlResult>ResultList>item"`
+
+	KfAccount     string
+	FromKfAccount string
+	ToKfAccount   string
+
+	SubscribeMsgPopupEvent  []SubscribeMsgItem `xml:"SubscribeMsgPopupEvent>List"`
+	SubscribeMsgChangeEvent []SubscribeMsgItem `xml:"SubscribeMsgChangeEvent>List"`
+	SubscribeMsgSentEvent   []SubscribeMsgItem `xml:"SubscribeMsgSentEvent>List"`
 }
 
 type defaultRequestMessage struct {
@@ -189,6 +299,20 @@ func (dft *defaultRequestMessage) MsgType() MsgType {
 			return LocationSelectEvenType
 		case templateSendJobFinishEventTypeValue:
 			return TemplateSendJobFinishEventType
+		case massSendJobFinishEventValue:
+			return MassSendJobFinishEventType
+		case kfCreateSessionEventValue:
+			return KfCreateSessionEventType
+		case kfCloseSessionEventValue:
+			return KfCloseSessionEventType
+		case kfSwitchSessionEventValue:
+			return KfSwitchSessionEventType
+		case subscribeMsgPopupEventValue:
+			return SubscribeMsgPopupEventType
+		case subscribeMsgChangeEventValue:
+			return SubscribeMsgChangeEventType
+		case subscribeMsgSentEventValue:
+			return SubscribeMsgSentEventType
 		}
 
 	}
@@ -199,6 +323,11 @@ func (dft *defaultRequestMessage) Content() string {
 	return dft.rm.Content
 }
 
+// 点击菜单消息时回传的菜单项id
+func (dft *defaultRequestMessage) BizMsgMenuId() string {
+	return dft.rm.BizMsgMenuId
+}
+
 func (dft *defaultRequestMessage) MsgId() int64 {
 	return dft.rm.MsgId
 }
@@ -295,6 +424,59 @@ func (dft *defaultRequestMessage) Status() string {
 	return dft.rm.Status
 }
 
+// 群发结果事件中的群发消息id，与群发接口返回的msg_id对应
+func (dft *defaultRequestMessage) MassMsgId() int64 {
+	return dft.rm.MsgID
+}
+
+func (dft *defaultRequestMessage) TotalCount() int {
+	return dft.rm.TotalCount
+}
+
+func (dft *defaultRequestMessage) FilterCount() int {
+	return dft.rm.FilterCount
+}
+
+func (dft *defaultRequestMessage) SentCount() int {
+	return dft.rm.SentCount
+}
+
+func (dft *defaultRequestMessage) ErrorCount() int {
+	return dft.rm.ErrorCount
+}
+
+func (dft *defaultRequestMessage) CopyrightCheckResult() CopyrightCheckResult {
+	return dft.rm.CopyrightCheckResult
+}
+
+func (dft *defaultRequestMessage) ArticleUrlResult() []ArticleUrlItem {
+	return dft.rm.ArticleUrlResult
+}
+
+func (dft *defaultRequestMessage) KfAccount() string {
+	return dft.rm.KfAccount
+}
+
+func (dft *defaultRequestMessage) FromKfAccount() string {
+	return dft.rm.FromKfAccount
+}
+
+func (dft *defaultRequestMessage) ToKfAccount() string {
+	return dft.rm.ToKfAccount
+}
+
+func (dft *defaultRequestMessage) SubscribeMsgPopupEvent() []SubscribeMsgItem {
+	return dft.rm.SubscribeMsgPopupEvent
+}
+
+func (dft *defaultRequestMessage) SubscribeMsgChangeEvent() []SubscribeMsgItem {
+	return dft.rm.SubscribeMsgChangeEvent
+}
+
+func (dft *defaultRequestMessage) SubscribeMsgSentEvent() []SubscribeMsgItem {
+	return dft.rm.SubscribeMsgSentEvent
+}
+
 type baseMessage interface {
 	ToUserName() string
 	FromUserName() string
@@ -306,6 +488,7 @@ type TextMessage interface {
 	baseMessage
 	MsgId() int64
 	Content() string
+	BizMsgMenuId() string
 }
 
 type ImageMessage interface {
@@ -398,3 +581,32 @@ type MenuClickEventMessage interface {
 	Event() string
 	EventKey() string
 }
+
+type SubscribeMsgEventMessage interface {
+	baseMessage
+	Event() string
+	SubscribeMsgPopupEvent() []SubscribeMsgItem
+	SubscribeMsgChangeEvent() []SubscribeMsgItem
+	SubscribeMsgSentEvent() []SubscribeMsgItem
+}
+
+type MassSendJobFinishEventMessage interface {
+	baseMessage
+	Event() string
+	MassMsgId() int64
+	Status() string
+	TotalCount() int
+	FilterCount() int
+	SentCount() int
+	ErrorCount() int
+	CopyrightCheckResult() CopyrightCheckResult
+	ArticleUrlResult() []ArticleUrlItem
+}
+
+type KfSessionEventMessage interface {
+	baseMessage
+	Event() string
+	KfAccount() string
+	FromKfAccount() string
+	ToKfAccount() string
+}
@@ -0,0 +1,57 @@
+package wechat
+
+import (
+	"container/list"
+	"strconv"
+)
+
+// 微信5秒内收不到回复会重新推送同一条消息，最多重试3次
+// 普通消息用MsgId排重，事件没有MsgId，用FromUserName+CreateTime排重
+func resendKey(r Request) string {
+	if id := r.MsgId(); id != 0 {
+		return strconv.FormatInt(id, 10)
+	}
+	return r.FromUserName() + "#" + strconv.Itoa(r.CreateTime())
+}
+
+// 记录重试排重key的条数 重试在15秒内完成，只需保留最近的消息
+const resendKeysSize = 10000
+
+type recentEntry struct {
+	key   string
+	value bool
+}
+
+// 有容量上限的LRU集合 超出容量时淘汰最久未访问的key，调用方负责加锁
+type recentKeys struct {
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+func newRecentKeys() *recentKeys {
+	return &recentKeys{ll: list.New(), m: make(map[string]*list.Element)}
+}
+
+func (s *recentKeys) get(key string) (value, ok bool) {
+	el, ok := s.m[key]
+	if !ok {
+		return
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*recentEntry).value, true
+}
+
+// 记录key size<=0时不限容量
+func (s *recentKeys) put(key string, value bool, size int) {
+	if el, ok := s.m[key]; ok {
+		el.Value.(*recentEntry).value = value
+		s.ll.MoveToFront(el)
+		return
+	}
+	s.m[key] = s.ll.PushFront(&recentEntry{key: key, value: value})
+	for size > 0 && s.ll.Len() > size {
+		el := s.ll.Back()
+		s.ll.Remove(el)
+		delete(s.m, el.Value.(*recentEntry).key)
+	}
+}
@@ -4,12 +4,57 @@ import (
 	"encoding/xml"
 	"errors"
 	"net/http"
+	"net/url"
+	"reflect"
 	"time"
+	"unicode/utf8"
+)
+
+// 被动回复的限制
+const (
+	MaxReplyTextBytes = 2048 // 文本内容最长字节数
+	MaxReplyArticles  = 1    // 图文消息条数
+)
+
+var (
+	// 图文条数超过MaxReplyArticles 沿用旧版本的变量名，旧版本的错误信息为article count over 10
+	ArticleCountOverError = errors.New("article count over limit")
+	NoArticleError        = errors.New("no article")
+	TextTooLongError      = errors.New("text content too long")
+	InvalidURLError       = errors.New("url must be http or https")
+	EmptyMediaIdError     = errors.New("empty media id")
+	ReplyWrittenError     = errors.New("reply already written")
+)
+
+// ValidateReply校验失败 可用errors.Is判断具体原因
+// NewArticleResponseMessage仍直接返回ArticleCountOverError，可继续用==比较
+type ReplyError struct {
+	MsgType string
+	Field   string
+	Err     error
+}
+
+func (e *ReplyError) Error() string {
+	return "invalid " + e.MsgType + " reply: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
+
+// 被动回复文本超过MaxReplyTextBytes时的处理方式
+type TextOverflow int
+
+const (
+	TextOverflowError    TextOverflow = iota // 返回TextTooLongError
+	TextOverflowTruncate                     // 在UTF-8字符边界截断
+	TextOverflowSplit                        // 第一段被动回复，其余部分通过客服消息依次发送
 )
 
 type defaultResponse struct {
-	c Context
-	w http.ResponseWriter
+	c     Context
+	w     http.ResponseWriter
+	reply *replyRecord
 }
 
 func (dr defaultResponse) String(s string) (err error) {
@@ -18,36 +63,81 @@ func (dr defaultResponse) String(s string) (err error) {
 }
 
 func (dr defaultResponse) Success() (err error) {
-	err = dr.Bytes([]byte("success"))
+	b := []byte("success")
+	err = dr.write(b, b, "success")
 	return
 }
 
 func (dr defaultResponse) Bytes(b []byte) (err error) {
+	return dr.write(b, b, "raw")
+}
+
+// 写入b并记录明文回复plain 每次回调只能回复一次，重复写入返回ReplyWrittenError
+func (dr defaultResponse) write(b, plain []byte, msgType string) (err error) {
+	if dr.reply != nil {
+		if dr.reply.written {
+			return ReplyWrittenError
+		}
+		dr.reply.written = true
+		dr.reply.data = plain
+		dr.reply.msgType = msgType
+	}
 	_, err = dr.w.Write(b)
 	return
 }
 
+// 回复消息结构体中的MsgType字段
+func replyMsgType(data interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName("MsgType"); f.IsValid() && f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return "raw"
+}
+
 func (dr defaultResponse) Response(data interface{}) (err error) {
-	b, err := xml.Marshal(data)
+	err = ValidateReply(data)
+	if err != nil {
+		return
+	}
+	plain, err := xml.Marshal(data)
 	if err != nil {
 		return
 	}
 
+	b := plain
 	if dr.c.Wechat().securityMode {
-		b, err = dr.c.Wechat().Encrypt(b)
+		b, err = dr.c.Wechat().Encrypt(plain)
 		if err != nil {
 			return
 		}
 	}
-	return dr.Bytes(b)
-}
-
-func (dr defaultResponse) Text(content string) error {
-	return dr.Response(NewTextResponseMessage(
+	return dr.write(b, plain, replyMsgType(data))
+}
+
+func (dr defaultResponse) Text(content string) (err error) {
+	var rest []string
+	if len(content) > MaxReplyTextBytes {
+		switch dr.c.Wechat().TextOverflow {
+		case TextOverflowTruncate:
+			content = splitUTF8(content, MaxReplyTextBytes)[0]
+		case TextOverflowSplit:
+			parts := splitUTF8(content, MaxReplyTextBytes)
+			content, rest = parts[0], parts[1:]
+		}
+	}
+	err = dr.Response(NewTextResponseMessage(
 		dr.c.Request().FromUserName(),
 		dr.c.Request().ToUserName(),
 		content,
 	))
+	if err == nil {
+		// 被动回复写出后由Server发送
+		dr.reply.followUp = rest
+	}
+	return
 }
 
 func (dr defaultResponse) Image(mediaId string) error {
@@ -95,6 +185,38 @@ func (dr defaultResponse) Article(articles ...ArticleItem) (err error) {
 	return dr.Response(article)
 }
 
+func (dr defaultResponse) TextTemplate(name string, data interface{}) error {
+	rt := dr.c.Wechat().ReplyTemplates()
+	if rt == nil {
+		return NoReplyTemplatesError
+	}
+	content, err := rt.Render(dr.c, name, data)
+	if err != nil {
+		return err
+	}
+	return dr.Text(content)
+}
+
+func (dr defaultResponse) ArticleTemplate(name string, data interface{}) error {
+	rt := dr.c.Wechat().ReplyTemplates()
+	if rt == nil {
+		return NoReplyTemplatesError
+	}
+	a, err := rt.RenderArticle(dr.c, name, data)
+	if err != nil {
+		return err
+	}
+	return dr.Article(a)
+}
+
+func (dr defaultResponse) TransferCustomerService(kfAccount string) error {
+	return dr.Response(NewTransferCustomerServiceResponseMessage(
+		dr.c.Request().FromUserName(),
+		dr.c.Request().ToUserName(),
+		kfAccount,
+	))
+}
+
 type CDATAString struct {
 	CDATA string `xml:",cdata"`
 }
@@ -262,12 +384,12 @@ type ArticleResponseMessage struct {
 	Articles     []ArticleItem
 }
 
-var ArticleCountOverError = errors.New("article count over 10")
-
+// 构造图文回复 微信现在只允许被动回复1条图文(旧版本允许10条)，
+// 超过MaxReplyArticles时返回ArticleCountOverError，多条图文改用客服消息或群发
 func NewArticleResponseMessage(to, from string, articles ...ArticleItem) (a ArticleResponseMessage, err error) {
 	count := len(articles)
 
-	if count > 10 {
+	if count > MaxReplyArticles {
 		err = ArticleCountOverError
 		return
 	}
@@ -282,3 +404,115 @@ func NewArticleResponseMessage(to, from string, articles ...ArticleItem) (a Arti
 	}
 	return
 }
+
+type TransInfo struct {
+	KfAccount CDATAString
+}
+
+type TransferCustomerServiceResponseMessage struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string
+	FromUserName string
+	CreateTime   int64
+	MsgType      string
+	TransInfo    *TransInfo `xml:",omitempty"`
+}
+
+// kfAccount为空时由微信分配空闲客服
+func NewTransferCustomerServiceResponseMessage(to, from, kfAccount string) TransferCustomerServiceResponseMessage {
+	m := TransferCustomerServiceResponseMessage{
+		ToUserName:   to,
+		FromUserName: from,
+		CreateTime:   time.Now().Unix(),
+		MsgType:      "transfer_customer_service",
+	}
+	if kfAccount != "" {
+		m.TransInfo = &TransInfo{KfAccount: CDATAString{CDATA: kfAccount}}
+	}
+	return m
+}
+
+// 按当前微信的限制校验被动回复，未知类型不做校验
+func ValidateReply(data interface{}) error {
+	switch m := data.(type) {
+	case *TextResponseMessage:
+		return ValidateReply(*m)
+	case TextResponseMessage:
+		if len(m.Content.CDATA) > MaxReplyTextBytes {
+			return &ReplyError{MsgType: "text", Field: "Content", Err: TextTooLongError}
+		}
+	case *ImageResponseMessage:
+		return ValidateReply(*m)
+	case ImageResponseMessage:
+		if m.Image.MediaId.CDATA == "" {
+			return &ReplyError{MsgType: "image", Field: "MediaId", Err: EmptyMediaIdError}
+		}
+	case *VoiceResponseMessage:
+		return ValidateReply(*m)
+	case VoiceResponseMessage:
+		if m.Voice.MediaId.CDATA == "" {
+			return &ReplyError{MsgType: "voice", Field: "MediaId", Err: EmptyMediaIdError}
+		}
+	case *VideoResponseMessage:
+		return ValidateReply(*m)
+	case VideoResponseMessage:
+		if m.Video.MediaId.CDATA == "" {
+			return &ReplyError{MsgType: "video", Field: "MediaId", Err: EmptyMediaIdError}
+		}
+	case *MusicResponseMessage:
+		return ValidateReply(*m)
+	case MusicResponseMessage:
+		if m.Music.ThumbMediaId == "" {
+			return &ReplyError{MsgType: "music", Field: "ThumbMediaId", Err: EmptyMediaIdError}
+		}
+		if !validReplyURL(m.Music.MusicUrl.CDATA) {
+			return &ReplyError{MsgType: "music", Field: "MusicUrl", Err: InvalidURLError}
+		}
+		if !validReplyURL(m.Music.HQMusicUrl.CDATA) {
+			return &ReplyError{MsgType: "music", Field: "HQMusicUrl", Err: InvalidURLError}
+		}
+	case *ArticleResponseMessage:
+		return ValidateReply(*m)
+	case ArticleResponseMessage:
+		if len(m.Articles) == 0 {
+			return &ReplyError{MsgType: "news", Field: "Articles", Err: NoArticleError}
+		}
+		if len(m.Articles) > MaxReplyArticles {
+			return &ReplyError{MsgType: "news", Field: "Articles", Err: ArticleCountOverError}
+		}
+		for _, a := range m.Articles {
+			if !validReplyURL(a.Item.Url.CDATA) {
+				return &ReplyError{MsgType: "news", Field: "Url", Err: InvalidURLError}
+			}
+			if !validReplyURL(a.Item.PicUrl.CDATA) {
+				return &ReplyError{MsgType: "news", Field: "PicUrl", Err: InvalidURLError}
+			}
+		}
+	}
+	return nil
+}
+
+// 为空或为http(s)绝对地址
+func validReplyURL(s string) bool {
+	if s == "" {
+		return true
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// 按不超过n字节拆分s，不会拆开多字节字符
+func splitUTF8(s string, n int) (parts []string) {
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n
+		}
+		parts = append(parts, s[:i])
+		s = s[i:]
+	}
+	return append(parts, s)
+}
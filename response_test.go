@@ -0,0 +1,112 @@
+package wechat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want []string
+	}{
+		{"", 4, []string{""}},
+		{"abc", 4, []string{"abc"}},
+		{"abcd", 4, []string{"abcd"}},
+		{"abcdefghi", 4, []string{"abcd", "efgh", "i"}},
+		// "你" "好" 各3字节，不能从中间拆开
+		{"你好", 4, []string{"你", "好"}},
+		{"a你好", 4, []string{"a你", "好"}},
+		{"ab你好", 4, []string{"ab", "你", "好"}},
+		// n小于单个字符的长度时按字节拆分
+		{"你", 2, []string{"你"[:2], "你"[2:]}},
+	}
+	for _, tt := range tests {
+		got := splitUTF8(tt.s, tt.n)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitUTF8(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if strings.Join(got, "") != tt.s {
+			t.Errorf("splitUTF8(%q, %d) lost data: %q", tt.s, tt.n, got)
+		}
+	}
+}
+
+func TestSplitUTF8Valid(t *testing.T) {
+	s := strings.Repeat("微信消息🙂", 300)
+	for _, p := range splitUTF8(s, MaxReplyTextBytes) {
+		if len(p) > MaxReplyTextBytes {
+			t.Fatalf("part too long: %d", len(p))
+		}
+		if !utf8.ValidString(p) {
+			t.Fatalf("invalid utf-8 part %q", p)
+		}
+	}
+}
+
+func TestValidateReply(t *testing.T) {
+	article := func(url, pic string) ArticleResponseMessage {
+		a, err := NewArticleResponseMessage("to", "from", NewArticleItem("title", "", pic, url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	twoArticles := article("", "")
+	twoArticles.Articles = append(twoArticles.Articles, NewArticleItem("b", "", "", ""))
+
+	tests := []struct {
+		name  string
+		data  interface{}
+		err   error
+		field string
+	}{
+		{"text", NewTextResponseMessage("to", "from", "hi"), nil, ""},
+		{"text max", NewTextResponseMessage("to", "from", strings.Repeat("a", MaxReplyTextBytes)), nil, ""},
+		{"text too long", NewTextResponseMessage("to", "from", strings.Repeat("a", MaxReplyTextBytes+1)), TextTooLongError, "Content"},
+		{"text pointer", func() interface{} {
+			m := NewTextResponseMessage("to", "from", strings.Repeat("a", MaxReplyTextBytes+1))
+			return &m
+		}(), TextTooLongError, "Content"},
+		{"image", NewImageResponseMessage("to", "from", "media"), nil, ""},
+		{"image empty", NewImageResponseMessage("to", "from", ""), EmptyMediaIdError, "MediaId"},
+		{"voice empty", NewVoiceResponseMessage("to", "from", ""), EmptyMediaIdError, "MediaId"},
+		{"music", NewMusicResponseMessage("to", "from", NewMusic("t", "d", "https://a.com/1.mp3", "", "thumb")), nil, ""},
+		{"music no thumb", NewMusicResponseMessage("to", "from", NewMusic("t", "d", "", "", "")), EmptyMediaIdError, "ThumbMediaId"},
+		{"music bad url", NewMusicResponseMessage("to", "from", NewMusic("t", "d", "ftp://a.com/1.mp3", "", "thumb")), InvalidURLError, "MusicUrl"},
+		{"music relative hq url", NewMusicResponseMessage("to", "from", NewMusic("t", "d", "", "/1.mp3", "thumb")), InvalidURLError, "HQMusicUrl"},
+		{"news", article("https://a.com", "http://a.com/1.jpg"), nil, ""},
+		{"news none", ArticleResponseMessage{}, NoArticleError, "Articles"},
+		{"news over", twoArticles, ArticleCountOverError, "Articles"},
+		{"news bad url", article("javascript:alert(1)", ""), InvalidURLError, "Url"},
+		{"news bad pic", article("", "a.com/1.jpg"), InvalidURLError, "PicUrl"},
+		{"unknown type", struct{}{}, nil, ""},
+	}
+	for _, tt := range tests {
+		err := ValidateReply(tt.data)
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		var re *ReplyError
+		if !errors.As(err, &re) || re.Field != tt.field {
+			t.Errorf("%s: got %#v, want field %s", tt.name, err, tt.field)
+		}
+	}
+}
+
+func TestNewArticleResponseMessageOver(t *testing.T) {
+	items := []ArticleItem{NewArticleItem("a", "", "", ""), NewArticleItem("b", "", "", "")}
+	if _, err := NewArticleResponseMessage("to", "from", items...); err != ArticleCountOverError {
+		t.Fatalf("got %v, want ArticleCountOverError", err)
+	}
+}
@@ -0,0 +1,155 @@
+package wechat
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// 一次扫码记录 Subscribe为true表示扫码后关注
+type ScanRecord struct {
+	Scene     string
+	OpenId    string
+	Subscribe bool
+	Time      time.Time
+}
+
+// 场景的转化统计
+type SceneStats struct {
+	Scans       int //扫码次数(含关注)
+	Subscribes  int //扫码关注次数
+	UniqueUsers int //扫码的不同用户数 见SceneRegistry.MaxTrackedUsers
+}
+
+// 扫码记录的存储 可实现为写入数据库供后续分析
+type SceneRecorder interface {
+	Record(r ScanRecord) error
+}
+
+// 带参数二维码的场景注册表
+// 根据场景值将扫码事件和扫码关注事件分发到对应处理器，并记录每次扫码用于统计活动转化
+// 微信重试推送的同一事件只统计一次，仍会交给处理器
+type SceneRegistry struct {
+	mtx      sync.RWMutex
+	handlers map[string]Handler
+	stats    map[string]*SceneStats
+	users    *recentKeys
+	resends  *recentKeys
+
+	// 统计UniqueUsers时最多记住的场景+用户数，默认100000
+	// 超出时淘汰最久未扫码的，之后再次扫码会重复计数；需要精确去重时用Recorder自行统计
+	MaxTrackedUsers int
+	// 额外的扫码记录存储，可为nil
+	Recorder SceneRecorder
+	// Recorder出错时调用，不影响扫码的处理
+	OnError func(err error)
+}
+
+func NewSceneRegistry() *SceneRegistry {
+	return &SceneRegistry{
+		handlers:        make(map[string]Handler),
+		stats:           make(map[string]*SceneStats),
+		users:           newRecentKeys(),
+		resends:         newRecentKeys(),
+		MaxTrackedUsers: 100000,
+	}
+}
+
+// 注册场景处理器 scene为二维码的scene_id或scene_str
+func (sr *SceneRegistry) Handle(scene string, h Handler) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	sr.handlers[scene] = h
+}
+
+func (sr *SceneRegistry) Stats(scene string) SceneStats {
+	sr.mtx.RLock()
+	defer sr.mtx.RUnlock()
+	if s, ok := sr.stats[scene]; ok {
+		return *s
+	}
+	return SceneStats{}
+}
+
+func (sr *SceneRegistry) AllStats() map[string]SceneStats {
+	sr.mtx.RLock()
+	defer sr.mtx.RUnlock()
+	m := make(map[string]SceneStats, len(sr.stats))
+	for k, s := range sr.stats {
+		m[k] = *s
+	}
+	return m
+}
+
+// 从扫码事件中取出场景值 扫码关注事件的EventKey带有qrscene_前缀
+func SceneFromRequest(r Request) (scene string, ok bool) {
+	switch r.MsgType() {
+	case ScanEventType:
+		return r.EventKey(), true
+	case ScanSubscribeEventType:
+		return strings.TrimPrefix(r.EventKey(), "qrscene_"), true
+	}
+	return "", false
+}
+
+// 记录一次扫码 key为重试排重key，已记录过时忽略
+func (sr *SceneRegistry) record(key string, rec ScanRecord) {
+	sr.mtx.Lock()
+	if _, ok := sr.resends.get(key); ok {
+		sr.mtx.Unlock()
+		return
+	}
+	sr.resends.put(key, true, resendKeysSize)
+	s, ok := sr.stats[rec.Scene]
+	if !ok {
+		s = &SceneStats{}
+		sr.stats[rec.Scene] = s
+	}
+	s.Scans++
+	if rec.Subscribe {
+		s.Subscribes++
+	}
+	user := rec.Scene + "\x00" + rec.OpenId
+	if _, ok := sr.users.get(user); !ok {
+		size := sr.MaxTrackedUsers
+		if size <= 0 {
+			size = 100000
+		}
+		sr.users.put(user, true, size)
+		s.UniqueUsers++
+	}
+	sr.mtx.Unlock()
+
+	if sr.Recorder != nil {
+		if err := sr.Recorder.Record(rec); err != nil && sr.OnError != nil {
+			sr.OnError(err)
+		}
+	}
+}
+
+// 中间件 记录扫码并交给场景处理器，未注册的场景交给后续处理器 用法: w.Use(registry.Middleware())
+func (sr *SceneRegistry) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) error {
+			r := c.Request()
+			scene, ok := SceneFromRequest(r)
+			if !ok {
+				return next(c)
+			}
+			sr.record(resendKey(r), ScanRecord{
+				Scene:     scene,
+				OpenId:    r.FromUserName(),
+				Subscribe: r.MsgType() == ScanSubscribeEventType,
+				Time:      time.Unix(int64(r.CreateTime()), 0),
+			})
+
+			sr.mtx.RLock()
+			h, ok := sr.handlers[scene]
+			sr.mtx.RUnlock()
+			if ok {
+				return h(c)
+			}
+			return next(c)
+		}
+	}
+}
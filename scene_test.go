@@ -0,0 +1,83 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func scanXML(openid string, createTime int, subscribe bool, scene string) string {
+	event, key := "SCAN", scene
+	if subscribe {
+		event, key = "subscribe", "qrscene_"+scene
+	}
+	return fmt.Sprintf(`<xml><ToUserName>gh</ToUserName><FromUserName>%s</FromUserName><CreateTime>%d</CreateTime><MsgType>event</MsgType><Event>%s</Event><EventKey>%s</EventKey></xml>`, openid, createTime, event, key)
+}
+
+type failingRecorder struct{ n int }
+
+func (f *failingRecorder) Record(r ScanRecord) error {
+	f.n++
+	return errors.New("db down")
+}
+
+func TestSceneRegistryStats(t *testing.T) {
+	sr := NewSceneRegistry()
+	var handled []string
+	sr.Handle("promo", func(c Context) error {
+		handled = append(handled, c.Request().FromUserName())
+		return nil
+	})
+	rec := &failingRecorder{}
+	var errs []error
+	sr.Recorder = rec
+	sr.OnError = func(err error) { errs = append(errs, err) }
+	h := sr.Middleware()(func(c Context) error { return nil })
+
+	scans := []struct {
+		openid     string
+		createTime int
+		subscribe  bool
+		scene      string
+	}{
+		{"a", 100, true, "promo"},
+		{"a", 100, true, "promo"}, // 微信重试
+		{"a", 200, false, "promo"},
+		{"b", 200, false, "promo"},
+		{"b", 200, false, "promo"}, // 微信重试
+		{"b", 300, false, "other"},
+	}
+	for _, s := range scans {
+		c, _ := newTestContext(t, scanXML(s.openid, s.createTime, s.subscribe, s.scene))
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := sr.Stats("promo"), (SceneStats{Scans: 3, Subscribes: 1, UniqueUsers: 2}); got != want {
+		t.Errorf("promo stats = %+v, want %+v", got, want)
+	}
+	if got, want := sr.Stats("other"), (SceneStats{Scans: 1, UniqueUsers: 1}); got != want {
+		t.Errorf("other stats = %+v, want %+v", got, want)
+	}
+	if len(handled) != 5 {
+		t.Errorf("handler called %d times, want 5 (resends are still handled)", len(handled))
+	}
+	if rec.n != 4 || len(errs) != 4 {
+		t.Errorf("recorder called %d times, %d errors reported, want 4", rec.n, len(errs))
+	}
+}
+
+func TestSceneRegistryMaxTrackedUsers(t *testing.T) {
+	sr := NewSceneRegistry()
+	sr.MaxTrackedUsers = 2
+	h := sr.Middleware()(func(c Context) error { return nil })
+	// a b 记住，a 重复不计，c 淘汰最久未扫码的b，a 仍在，b 被淘汰后再次扫码重复计数
+	for i, openid := range []string{"a", "b", "a", "c", "a", "b"} {
+		c, _ := newTestContext(t, scanXML(openid, i, false, "s"))
+		h(c)
+	}
+	if got := sr.Stats("s"); got.Scans != 6 || got.UniqueUsers != 4 {
+		t.Errorf("stats = %+v", got)
+	}
+}
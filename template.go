@@ -0,0 +1,355 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/slrem/wechat/trader"
+)
+
+var (
+	NoReplyTemplatesError = errors.New("reply templates not set")
+	NoTemplatesError      = errors.New("no reply template matched")
+)
+
+// 渲染回复模板时的数据
+/*
+	{{.FromUserName}} {{.Content}} {{.EventKey}}
+	{{with .User}}{{.NickName}}{{end}}  首次访问时拉取用户信息，拉取失败时为nil
+	{{.Data.xxx}}                       调用方传入的数据
+*/
+type ReplyData struct {
+	FromUserName string
+	ToUserName   string
+	Content      string
+	EventKey     string
+	MsgType      MsgType
+	Request      Request
+	Data         interface{}
+
+	w    *Wechat
+	once sync.Once
+	user *trader.UserInfo
+	err  error
+}
+
+func newReplyData(c Context, data interface{}) *ReplyData {
+	r := c.Request()
+	return &ReplyData{
+		FromUserName: r.FromUserName(),
+		ToUserName:   r.ToUserName(),
+		Content:      r.Content(),
+		EventKey:     r.EventKey(),
+		MsgType:      r.MsgType(),
+		Request:      r,
+		Data:         data,
+		w:            c.Wechat(),
+	}
+}
+
+// 发送者的用户信息 只在模板中用到时才拉取，拉取失败时返回nil而不中断渲染
+func (d *ReplyData) User() *trader.UserInfo {
+	d.fetchUser()
+	return d.user
+}
+
+// 拉取用户信息的错误
+func (d *ReplyData) UserErr() error {
+	d.fetchUser()
+	return d.err
+}
+
+func (d *ReplyData) fetchUser() {
+	d.once.Do(func() {
+		if d.w == nil || d.w.Trader() == nil {
+			d.err = errors.New("trader not set")
+			return
+		}
+		u, err := d.w.Trader().GetUserInfo(d.FromUserName)
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.user = &u
+	})
+}
+
+// 常用微信表情的文本代码
+var emojiCodes = map[string]string{
+	"微笑": "/::)",
+	"撇嘴": "/::~",
+	"色":  "/::B",
+	"发呆": "/::|",
+	"得意": "/:8-)",
+	"流泪": "/::<",
+	"害羞": "/::$",
+	"闭嘴": "/::X",
+	"睡":  "/::Z",
+	"大哭": "/::'(",
+	"尴尬": "/::-|",
+	"发怒": "/::@",
+	"调皮": "/::P",
+	"呲牙": "/::D",
+	"惊讶": "/::O",
+	"难过": "/::(",
+	"酷":  "/::+",
+	"抓狂": "/::Q",
+	"吐":  "/::T",
+	"偷笑": "/:,@P",
+	"愉快": "/:,@-D",
+	"白眼": "/::d",
+	"傲慢": "/:,@o",
+	"困":  "/:|-)",
+	"惊恐": "/::!",
+	"流汗": "/::L",
+	"憨笑": "/::>",
+	"悠闲": "/::,@",
+	"奋斗": "/:,@f",
+	"咒骂": "/::-S",
+	"疑问": "/:?",
+	"嘘":  "/:,@x",
+	"晕":  "/:,@@",
+	"衰":  "/:,@!",
+	"骷髅": "/:!!!",
+	"敲打": "/:xx",
+	"再见": "/:bye",
+	"擦汗": "/:wipe",
+	"抠鼻": "/:dig",
+	"鼓掌": "/:handclap",
+	"坏笑": "/:B-)",
+	"玫瑰": "/:rose",
+	"强":  "/:strong",
+	"弱":  "/:weak",
+	"握手": "/:share",
+	"胜利": "/:v",
+	"抱拳": "/:@)",
+	"爱心": "/:heart",
+	"心碎": "/:break",
+	"蛋糕": "/:cake",
+	"月亮": "/:moon",
+	"太阳": "/:sun",
+	"礼物": "/:gift",
+	"拥抱": "/:hug",
+	"咖啡": "/:coffee",
+	"OK": "/:ok",
+}
+
+// 模板中可用的函数
+/*
+	{{emoji "微笑"}}                                  微信表情，未收录的名称输出为[名称]
+	{{link "https://example.com" "点这里"}}           文本回复中的超链接
+	{{miniprogram "appid" "pages/index/index" "打开小程序"}}
+	{{miniprogram "appid" "pages/index/index" "打开小程序" "https://example.com"}}  最后一个参数为不支持小程序的客户端打开的地址
+*/
+var replyFuncs = template.FuncMap{
+	"emoji": func(name string) string {
+		if c, ok := emojiCodes[name]; ok {
+			return c
+		}
+		return "[" + name + "]"
+	},
+	"link": func(href, text string) string {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), html.EscapeString(text))
+	},
+	"miniprogram": func(appid, path, text string, href ...string) string {
+		s := fmt.Sprintf(`<a data-miniprogram-appid="%s" data-miniprogram-path="%s"`, html.EscapeString(appid), html.EscapeString(path))
+		if len(href) > 0 && href[0] != "" {
+			s += fmt.Sprintf(` href="%s"`, html.EscapeString(href[0]))
+		}
+		return s + ">" + html.EscapeString(text) + "</a>"
+	},
+}
+
+// 回复模板 从fs.FS中按patterns加载，模板名为文件名或{{define}}定义的名称
+/*
+	rt, err := wechat.NewReplyTemplatesFromDir("replies", "*.tmpl")
+	w.SetReplyTemplates(rt)
+	rt.Watch(5 * time.Second)
+
+	w.Text(func(c wechat.Context) error {
+		return c.Response().TextTemplate("welcome.tmpl", nil)
+	})
+
+	图文模板由 <name>.title、<name>.description、<name>.picurl、<name>.url 四个子模板组成，缺少的部分为空
+*/
+type ReplyTemplates struct {
+	fsys     fs.FS
+	patterns []string
+
+	mtx  sync.RWMutex
+	tmpl *template.Template
+	sig  string
+
+	// 重新加载失败时调用，继续使用之前的模板
+	OnReloadError func(err error)
+
+	stop chan struct{}
+}
+
+func NewReplyTemplates(fsys fs.FS, patterns ...string) (*ReplyTemplates, error) {
+	rt := &ReplyTemplates{fsys: fsys, patterns: patterns}
+	if err := rt.Reload(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func NewReplyTemplatesFromDir(dir string, patterns ...string) (*ReplyTemplates, error) {
+	return NewReplyTemplates(os.DirFS(dir), patterns...)
+}
+
+// 重新加载全部模板 解析失败时保留原有模板
+func (rt *ReplyTemplates) Reload() (err error) {
+	sig, err := rt.signature()
+	if err != nil {
+		return
+	}
+	if sig == "" {
+		return NoTemplatesError
+	}
+	t, err := template.New("").Funcs(replyFuncs).ParseFS(rt.fsys, rt.patterns...)
+	if err != nil {
+		return
+	}
+	rt.mtx.Lock()
+	rt.tmpl, rt.sig = t, sig
+	rt.mtx.Unlock()
+	return
+}
+
+// 匹配文件的名称、大小和修改时间，用于判断是否需要重新加载
+func (rt *ReplyTemplates) signature() (string, error) {
+	var names []string
+	for _, p := range rt.patterns {
+		list, err := fs.Glob(rt.fsys, p)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, list...)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fi, err := fs.Stat(rt.fsys, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", name, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// 每隔interval检查一次模板文件，有变化时重新加载，直到调用Stop
+func (rt *ReplyTemplates) Watch(interval time.Duration) {
+	rt.mtx.Lock()
+	if rt.stop != nil {
+		rt.mtx.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	rt.stop = stop
+	rt.mtx.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				rt.reloadIfChanged()
+			}
+		}
+	}()
+}
+
+func (rt *ReplyTemplates) Stop() {
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	if rt.stop != nil {
+		close(rt.stop)
+		rt.stop = nil
+	}
+}
+
+func (rt *ReplyTemplates) reloadIfChanged() {
+	sig, err := rt.signature()
+	if err == nil {
+		rt.mtx.RLock()
+		changed := sig != rt.sig
+		rt.mtx.RUnlock()
+		if !changed {
+			return
+		}
+		err = rt.Reload()
+	}
+	if err != nil && rt.OnReloadError != nil {
+		rt.OnReloadError(err)
+	}
+}
+
+func (rt *ReplyTemplates) template() *template.Template {
+	rt.mtx.RLock()
+	defer rt.mtx.RUnlock()
+	return rt.tmpl
+}
+
+// 是否存在名为name的模板
+func (rt *ReplyTemplates) Has(name string) bool {
+	return rt.template().Lookup(name) != nil
+}
+
+// 用请求数据渲染模板 data可在模板中用.Data访问
+func (rt *ReplyTemplates) Render(c Context, name string, data interface{}) (string, error) {
+	return rt.render(rt.template(), name, newReplyData(c, data))
+}
+
+func (rt *ReplyTemplates) render(t *template.Template, name string, d *ReplyData) (string, error) {
+	var b strings.Builder
+	err := t.ExecuteTemplate(&b, name, d)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// 渲染图文模板
+func (rt *ReplyTemplates) RenderArticle(c Context, name string, data interface{}) (a ArticleItem, err error) {
+	t := rt.template()
+	d := newReplyData(c, data)
+	var parts [4]string
+	found := false
+	for i, suffix := range []string{".title", ".description", ".picurl", ".url"} {
+		if t.Lookup(name+suffix) == nil {
+			continue
+		}
+		found = true
+		parts[i], err = rt.render(t, name+suffix, d)
+		if err != nil {
+			return
+		}
+	}
+	if !found {
+		err = fmt.Errorf("template: no article template %q", name)
+		return
+	}
+	a = NewArticleItem(parts[0], parts[1], parts[2], parts[3])
+	return
+}
+
+func (w *Wechat) SetReplyTemplates(rt *ReplyTemplates) {
+	w.replyTemplates = rt
+}
+
+func (w *Wechat) ReplyTemplates() *ReplyTemplates {
+	return w.replyTemplates
+}
@@ -0,0 +1,35 @@
+package wechat
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestReplyTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{`{{emoji "微笑"}}`, "/::)"},
+		{`{{emoji "酷"}}`, "/::+"},
+		{`{{emoji "没有"}}`, "[没有]"},
+		{`{{link "https://example.com/?a=1&b=2" "点这里"}}`, `<a href="https://example.com/?a=1&amp;b=2">点这里</a>`},
+		{`{{link "https://example.com" .Content}}`, `<a href="https://example.com">&lt;/a&gt;&lt;a href=&#34;x&#34;&gt;</a>`},
+		{`{{miniprogram "wx1" "pages/index" "打开"}}`, `<a data-miniprogram-appid="wx1" data-miniprogram-path="pages/index">打开</a>`},
+		{`{{miniprogram "wx1" "pages/index?a=1&b=2" "<b>" "https://example.com"}}`, `<a data-miniprogram-appid="wx1" data-miniprogram-path="pages/index?a=1&amp;b=2" href="https://example.com">&lt;b&gt;</a>`},
+	}
+	c, _ := newTestContext(t, `<xml><FromUserName>u</FromUserName><MsgType>text</MsgType><Content><![CDATA[</a><a href="x">]]></Content></xml>`)
+	for _, tt := range tests {
+		rt, err := NewReplyTemplates(fstest.MapFS{"t.tmpl": {Data: []byte(tt.tmpl)}}, "*.tmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rt.Render(c, "t.tmpl", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.tmpl, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.tmpl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/*
+  自动回复规则
+  获取公众号在管理后台设置的关注后自动回复、消息自动回复和关键词自动回复，
+  通过API设置的规则不会出现在这里
+*/
+
+type AutoreplyInfo struct {
+	IsAddFriendReplyOpen        int                      `json:"is_add_friend_reply_open"`
+	IsAutoreplyOpen             int                      `json:"is_autoreply_open"`
+	AddFriendAutoreplyInfo      *AutoreplyContent        `json:"add_friend_autoreply_info"`
+	MessageDefaultAutoreplyInfo *AutoreplyContent        `json:"message_default_autoreply_info"`
+	KeywordAutoreplyInfo        KeywordAutoreplyInfoList `json:"keyword_autoreply_info"`
+}
+
+type KeywordAutoreplyInfoList struct {
+	List []KeywordAutoreplyRule `json:"list"`
+}
+
+type KeywordAutoreplyRule struct {
+	RuleName        string             `json:"rule_name"`
+	CreateTime      int64              `json:"create_time"`
+	ReplyMode       string             `json:"reply_mode"` // reply_all 全部回复，random_one 随机回复其中一条
+	KeywordListInfo []AutoreplyKeyword `json:"keyword_list_info"`
+	ReplyListInfo   []AutoreplyContent `json:"reply_list_info"`
+}
+
+type AutoreplyKeyword struct {
+	Type      string `json:"type"`
+	MatchMode string `json:"match_mode"` // contain 消息中含有该关键词即可，equal 消息内容必须和关键词严格相同
+	Content   string `json:"content"`
+}
+
+// type为text时content为文本，img、voice、video时为media_id，news时内容在NewsInfo中
+type AutoreplyContent struct {
+	Type     string             `json:"type"`
+	Content  string             `json:"content"`
+	NewsInfo *AutoreplyNewsList `json:"news_info,omitempty"`
+}
+
+type AutoreplyNewsList struct {
+	List []AutoreplyNews `json:"list"`
+}
+
+type AutoreplyNews struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Digest     string `json:"digest"`
+	ShowCover  int    `json:"show_cover"`
+	CoverUrl   string `json:"cover_url"`
+	ContentUrl string `json:"content_url"`
+	SourceUrl  string `json:"source_url"`
+}
+
+// 获取公众号当前的自动回复规则
+func (t *Trader) GetCurrentAutoreplyInfo() (info AutoreplyInfo, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/get_current_autoreply_info?access_token=" + t.Accesstoken
+	b, err := t.Get(surl)
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	err = json.Unmarshal(b, &info)
+	return
+}
@@ -6,9 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
-	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -30,18 +29,7 @@ func (t *Trader) UpLoadImg(data []byte) (url string, err error) {
 		return
 	}
 	surl := MediaURL + "uploadimg?access_token=" + t.Accesstoken
-	req, err := http.NewRequest("POST", surl, buf)
-	if err != nil {
-		return
-	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
-	var client http.Client
-	res, err := client.Do(req)
-	if err != nil {
-		return
-	}
-	defer res.Body.Close()
-	aaa, err := ioutil.ReadAll(res.Body)
+	aaa, err := t.PostMultipart(surl, w.FormDataContentType(), buf)
 	if err != nil {
 		return
 	}
@@ -95,131 +83,152 @@ func (t *Trader) UploadNews(articles []NewsArticle) (mediaId string, err error)
 	return
 }
 
-//根据标签进行群发【订阅号与服务号认证后均可用】
-func (t *Trader) createMsgJson(msgtype string, tagId int, context string) (jsonstr string, err error) {
-	type Filter struct {
-		IsToAll bool `json:"is_to_all"`
-		TagID   int  `json:"tag_id"`
-	}
+func newMassMessage(msgtype, content string) MassMessage {
 	switch msgtype {
 	case mpnewsType:
-		var c struct {
-			Filter            Filter `json:"filter"`
-			MpNews            MpNews `json:"mpnews"`
-			MsgType           string `json:"msgtype"`
-			SendIgnoreReprint int    `json:"send_ignore_reprint"`
-		}
-		c.MsgType, c.MpNews.MediaId = mpnewsType, context
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassMpNews(content)
 	case textType:
-		var c struct {
-			Filter  Filter `json:"filter"`
-			Text    Text   `json:"text"`
-			MsgType string `json:"msgtype"`
-		}
-		c.MsgType, c.Text.Content = textType, context
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassText(content)
 	case voiceType:
-		var c struct {
-			Filter  Filter `json:"filter"`
-			Voice   Voice  `json:"voice"`
-			MsgType string `json:"msgtype"`
-		}
-		c.Voice.MediaId, c.MsgType = context, voiceType
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassVoice(content)
 	case imageType:
-		var c struct {
-			Filter  Filter `json:"filter"`
-			Image   Image  `json:"image"`
-			MsgType string `json:"msgtype"`
-		}
-		c.Image.MediaId, c.MsgType = context, imageType
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassImage(content)
 	case MpVideoType:
-		var c struct {
-			Filter  Filter `json:"filter"`
-			Mpvideo struct {
-				MediaId string `json:"media_id"`
-			} `json:"mpvideo"`
-			MsgType string `json:"msgtype"`
-		}
-		c.Mpvideo.MediaId, c.MsgType = context, MpVideoType
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassMpVideo(content)
 	case wxcardType:
-		var c struct {
-			Filter  Filter `json:"filter"`
-			WxCard  WxCard `json:"wxcard"`
-			MsgType string `json:"msgtype"`
-		}
-		c.WxCard.CardId, c.MsgType = context, wxcardType
-		if tagId == 0 {
-			c.Filter.IsToAll = true
-		} else {
-			c.Filter.IsToAll = false
-			c.Filter.TagID = tagId
-		}
-		str, err := json.Marshal(c)
-		return string(str), err
+		return NewMassWxCard(content)
 	}
-	return
+	return MassMessage{MsgType: msgtype}
 }
+
 func (t *Trader) sendAll(msgtype string, tagId int, mediaId string) (s SendAllResp, err error) {
-	err = t.CheckAccessTokenLive()
+	return t.SendAll(tagId, newMassMessage(msgtype, mediaId), SendAllOptions{})
+}
+
+func (t *Trader) postMass(surl string, p interface{}) (s SendAllResp, err error) {
+	str, err := json.Marshal(p)
 	if err != nil {
 		return
 	}
-	surl := SendAllURL + t.Accesstoken
-	str, err := t.createMsgJson(msgtype, tagId, mediaId)
+	b, err := t.PostJson(surl, string(str))
 	if err != nil {
 		return
 	}
-	b, err := t.PostJson(surl, str)
+	err = json.Unmarshal(b, &s)
 	if err != nil {
 		return
 	}
-
-	err = json.Unmarshal(b, &s)
 	if s.ErrCode != 0 {
 		err = errors.New(string(b))
 	}
 	return
 }
 
+//根据标签进行群发【订阅号与服务号认证后均可用】 tagId为0时发送给全部用户
+func (t *Trader) SendAll(tagId int, msg MassMessage, opt SendAllOptions) (s SendAllResp, err error) {
+	if len(opt.ClientMsgId) > MaxClientMsgIdLen {
+		err = ClientMsgIdTooLongError
+		return
+	}
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	type Filter struct {
+		IsToAll bool `json:"is_to_all"`
+		TagID   int  `json:"tag_id,omitempty"`
+	}
+	var p struct {
+		Filter Filter `json:"filter"`
+		MassMessage
+		SendIgnoreReprint int    `json:"send_ignore_reprint"`
+		ClientMsgId       string `json:"clientmsgid,omitempty"`
+	}
+	p.MassMessage, p.ClientMsgId = msg, opt.ClientMsgId
+	if opt.SendIgnoreReprint {
+		p.SendIgnoreReprint = 1
+	}
+	if tagId == 0 {
+		p.Filter.IsToAll = true
+	} else {
+		p.Filter.TagID = tagId
+	}
+	return t.postMass(SendAllURL+t.Accesstoken, p)
+}
+
+//根据OpenID列表群发的单次上限
+const MassOpenIdLimit = 10000
+
+//clientmsgid的最大长度
+const MaxClientMsgIdLen = 64
+
+var ClientMsgIdTooLongError = errors.New("clientmsgid长度不能超过64")
+
+//n片群发中第i片使用的clientmsgid 只有一片时为原值，否则为 原值-序号，超长时以原值的sha1代替原值
+func chunkClientMsgId(id string, i, n int) string {
+	if id == "" || n == 1 {
+		return id
+	}
+	suffix := "-" + strconv.Itoa(i)
+	if len(id)+len(suffix) > MaxClientMsgIdLen {
+		id = sha(id)
+	}
+	return id + suffix
+}
+
+//按MassOpenIdLimit拆分openid列表 单次群发至少需要2个openid，最后一片只有1个时从前一片借1个
+func massChunks(openids []string) [][]string {
+	chunks := chunkStrings(openids, MassOpenIdLimit)
+	if n := len(chunks); n > 1 && len(chunks[n-1]) == 1 {
+		prev := chunks[n-2]
+		chunks[n-2] = prev[:len(prev)-1]
+		chunks[n-1] = openids[len(openids)-2:]
+	}
+	return chunks
+}
+
+//根据OpenID列表群发【订阅号不可用，服务号认证后可用】
+/*
+openids 超过10000个时自动拆分为多次群发，每次的结果按顺序返回
+拆分时每次群发使用 ClientMsgId-序号 作为clientmsgid，加上序号超过64个字符时以ClientMsgId的sha1代替
+*/
+func (t *Trader) SendByOpenIds(openids []string, msg MassMessage, opt SendAllOptions) (list []SendAllResp, err error) {
+	if len(openids) < 2 {
+		err = errors.New("openid列表至少需要2个")
+		return
+	}
+	if len(opt.ClientMsgId) > MaxClientMsgIdLen {
+		err = ClientMsgIdTooLongError
+		return
+	}
+	var p struct {
+		ToUser []string `json:"touser"`
+		MassMessage
+		SendIgnoreReprint int    `json:"send_ignore_reprint"`
+		ClientMsgId       string `json:"clientmsgid,omitempty"`
+	}
+	p.MassMessage = msg
+	if opt.SendIgnoreReprint {
+		p.SendIgnoreReprint = 1
+	}
+	chunks := massChunks(openids)
+	for i, ids := range chunks {
+		p.ToUser = ids
+		p.ClientMsgId = chunkClientMsgId(opt.ClientMsgId, i, len(chunks))
+		err = t.CheckAccessTokenLive()
+		if err != nil {
+			return
+		}
+		var s SendAllResp
+		s, err = t.postMass(SendMassURL+t.Accesstoken, p)
+		if err != nil {
+			return
+		}
+		list = append(list, s)
+	}
+	return
+}
+
 //群发图文
 func (t *Trader) SendMpNewsAll(tagId int, mediaId string) (msgid, msgdataid int, err error) {
 	m, err := t.sendAll(mpnewsType, tagId, mediaId)
@@ -0,0 +1,121 @@
+package trader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMassChunks(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{2, []int{2}},
+		{MassOpenIdLimit, []int{MassOpenIdLimit}},
+		{MassOpenIdLimit + 1, []int{MassOpenIdLimit - 1, 2}},
+		{MassOpenIdLimit + 2, []int{MassOpenIdLimit, 2}},
+		{2*MassOpenIdLimit + 1, []int{MassOpenIdLimit, MassOpenIdLimit - 1, 2}},
+	}
+	for _, tt := range tests {
+		ids := make([]string, tt.n)
+		for i := range ids {
+			ids[i] = fmt.Sprint(i)
+		}
+		chunks := massChunks(ids)
+		var sizes []int
+		var all []string
+		for _, c := range chunks {
+			sizes = append(sizes, len(c))
+			all = append(all, c...)
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(tt.want) {
+			t.Errorf("%d openids: chunk sizes %v, want %v", tt.n, sizes, tt.want)
+		}
+		if strings.Join(all, ",") != strings.Join(ids, ",") {
+			t.Errorf("%d openids: chunks do not cover the list in order", tt.n)
+		}
+	}
+}
+
+func TestChunkClientMsgId(t *testing.T) {
+	long := strings.Repeat("a", MaxClientMsgIdLen)
+	tests := []struct {
+		id   string
+		i, n int
+		want string
+	}{
+		{"", 1, 3, ""},
+		{"job", 0, 1, "job"},
+		{long, 0, 1, long},
+		{"job", 0, 3, "job-0"},
+		{"job", 2, 3, "job-2"},
+		{strings.Repeat("a", MaxClientMsgIdLen-2), 1, 2, strings.Repeat("a", MaxClientMsgIdLen-2) + "-1"},
+		{strings.Repeat("a", MaxClientMsgIdLen-1), 1, 2, sha(strings.Repeat("a", MaxClientMsgIdLen-1)) + "-1"},
+		{long, 12, 13, sha(long) + "-12"},
+	}
+	for _, tt := range tests {
+		got := chunkClientMsgId(tt.id, tt.i, tt.n)
+		if got != tt.want {
+			t.Errorf("chunkClientMsgId(%q, %d, %d) = %q, want %q", tt.id, tt.i, tt.n, got, tt.want)
+		}
+		if len(got) > MaxClientMsgIdLen {
+			t.Errorf("chunkClientMsgId(%q, %d, %d) is %d characters", tt.id, tt.i, tt.n, len(got))
+		}
+	}
+}
+
+func TestSendByOpenIdsClientMsgId(t *testing.T) {
+	var mtx sync.Mutex
+	var got []string
+	tr := newStubTrader(t, func(w http.ResponseWriter, r *http.Request) {
+		var p struct {
+			ClientMsgId string `json:"clientmsgid"`
+		}
+		json.NewDecoder(r.Body).Decode(&p)
+		mtx.Lock()
+		got = append(got, p.ClientMsgId)
+		mtx.Unlock()
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"send job submission success","msg_id":1}`)
+	})
+
+	long := strings.Repeat("x", MaxClientMsgIdLen)
+	if _, err := tr.SendByOpenIds([]string{"a", "b"}, NewMassText("hi"), SendAllOptions{ClientMsgId: long}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != long {
+		t.Fatalf("single chunk sent clientmsgid %q, want the caller's id", got)
+	}
+
+	got = nil
+	ids := make([]string, MassOpenIdLimit+2)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	list, err := tr.SendByOpenIds(ids, NewMassText("hi"), SendAllOptions{ClientMsgId: long})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || len(got) != 2 {
+		t.Fatalf("%d results, %d requests, want 2", len(list), len(got))
+	}
+	for i, id := range got {
+		if want := fmt.Sprintf("%s-%d", sha(long), i); id != want {
+			t.Errorf("chunk %d clientmsgid %q, want %q", i, id, want)
+		}
+	}
+
+	got = nil
+	if _, err := tr.SendByOpenIds([]string{"a", "b"}, NewMassText("hi"), SendAllOptions{ClientMsgId: long + "x"}); err != ClientMsgIdTooLongError {
+		t.Errorf("err = %v, want ClientMsgIdTooLongError", err)
+	}
+	if _, err := tr.SendAll(1, NewMassText("hi"), SendAllOptions{ClientMsgId: long + "x"}); err != ClientMsgIdTooLongError {
+		t.Errorf("SendAll err = %v, want ClientMsgIdTooLongError", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("over-long clientmsgid reached the API: %q", got)
+	}
+}
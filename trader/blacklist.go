@@ -0,0 +1,111 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/*
+  黑名单管理
+*/
+
+// 批量拉黑/取消拉黑的单次上限
+const BatchBlacklistLimit = 20
+
+// 获取黑名单列表 beginopenid为空时从头开始，每次最多返回10000个
+func (t *Trader) GetBlacklist(beginopenid string) (openids []string, nextopenid string, total int, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := TagsURL + "members/getblacklist?access_token=" + t.Accesstoken
+	var p struct {
+		BeginOpenId string `json:"begin_openid"`
+	}
+	p.BeginOpenId = beginopenid
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode int `json:"errcode"`
+		Total   int `json:"total"`
+		Count   int `json:"count"`
+		Data    struct {
+			OpenId []string `json:"openid"`
+		} `json:"data"`
+		NextOpenId string `json:"next_openid"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	openids, nextopenid, total = r.Data.OpenId, r.NextOpenId, r.Total
+	return
+}
+
+// 逐页遍历黑名单 用法同FansIterator
+func (t *Trader) BlacklistIterator(beginopenid string) *OpenIdIterator {
+	return newOpenIdIterator(beginopenid, t.GetBlacklist)
+}
+
+// 获取全部黑名单
+func (t *Trader) GetAllBlacklist() (openids []string, err error) {
+	it := t.BlacklistIterator("")
+	for it.Next() {
+		openids = append(openids, it.Page()...)
+	}
+	err = it.Err()
+	return
+}
+
+// 拉黑用户 超过20个时自动拆分，部分失败时返回*BatchError
+func (t *Trader) BatchBlacklist(openids []string) error {
+	return t.batchChunks(openids, BatchBlacklistLimit, func(ids []string) error {
+		return t.blacklist("batchblacklist", ids)
+	})
+}
+
+// 取消拉黑用户 超过20个时自动拆分，部分失败时返回*BatchError
+func (t *Trader) BatchUnblacklist(openids []string) error {
+	return t.batchChunks(openids, BatchBlacklistLimit, func(ids []string) error {
+		return t.blacklist("batchunblacklist", ids)
+	})
+}
+
+func (t *Trader) blacklist(action string, openids []string) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := TagsURL + "members/" + action + "?access_token=" + t.Accesstoken
+	var p struct {
+		OpenIds []string `json:"openid_list"`
+	}
+	p.OpenIds = openids
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
@@ -0,0 +1,347 @@
+package trader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  模板消息批量发送
+  并发数受Workers限制，发送速度受令牌桶限制，临时错误(系统繁忙、网络超时等)自动重试，
+  永久错误(用户取消关注、拒收等)直接跳过，模板或参数错误时中止整批发送，
+  进度写入Checkpoint以便中断后续传 失败和取消的接收者在续传时会重新发送
+*/
+
+type BulkStatus string
+
+const (
+	BulkSent    BulkStatus = "sent"
+	BulkFailed  BulkStatus = "failed"
+	BulkSkipped BulkStatus = "skipped"
+	// 批量发送被中止，未发送
+	BulkCancelled BulkStatus = "cancelled"
+)
+
+// 单个接收者
+type TemplateRecipient struct {
+	OpenId      string
+	Url         string
+	Miniprogram *TemplateMiniprogram
+	Data        map[string]TemplateData
+}
+
+// 单个接收者的发送结果
+type BulkResult struct {
+	OpenId   string     `json:"openid"`
+	Status   BulkStatus `json:"status"`
+	MsgId    int        `json:"msgid,omitempty"`
+	ErrCode  int        `json:"errcode,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Attempts int        `json:"attempts"`
+	Resumed  bool       `json:"resumed,omitempty"`
+}
+
+// 批量发送报告
+type BulkReport struct {
+	Total     int
+	Sent      int
+	Failed    int
+	Skipped   int
+	Cancelled int
+	Resumed   int
+	Results   []BulkResult
+}
+
+func (r *BulkReport) add(res BulkResult) {
+	r.Total++
+	if res.Resumed {
+		r.Resumed++
+	}
+	switch res.Status {
+	case BulkSent:
+		r.Sent++
+	case BulkFailed:
+		r.Failed++
+	case BulkSkipped:
+		r.Skipped++
+	case BulkCancelled:
+		r.Cancelled++
+	}
+	r.Results = append(r.Results, res)
+}
+
+// 发送进度记录 Load返回已完成的结果，Save在每个接收者完成后调用
+type BulkCheckpoint interface {
+	Load() (map[string]BulkResult, error)
+	Save(res BulkResult) error
+}
+
+type BulkTemplateOptions struct {
+	TemplateId string
+	Workers    int     //并发数 默认4
+	Rate       float64 //每秒发送数 <=0表示不限速
+	Burst      int     //令牌桶容量 默认为1
+	MaxRetries int     //临时错误最大重试次数 0为默认的3次，<0表示不重试
+	Checkpoint BulkCheckpoint
+}
+
+// 临时错误 稍后重试可能成功
+func isTransientErrCode(code int) bool {
+	switch code {
+	case -1, 45011:
+		return true
+	}
+	return isTokenErrCode(code)
+}
+
+// 可重试的错误 接口返回临时错误码，或超时、连接断开等网络错误
+// ctx的取消、数据序列化失败等其他错误不重试
+func isTransientError(err error) bool {
+	if code := ErrCode(err); code != 0 {
+		return isTransientErrCode(code)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// 已有结果且无需重新发送
+func bulkDone(res BulkResult) bool {
+	return res.Status == BulkSent || res.Status == BulkSkipped
+}
+
+// 永久错误 重试无意义 直接跳过
+func isPermanentErrCode(code int) bool {
+	switch code {
+	case 40003, 43004, 43019, 43101:
+		return true
+	}
+	return false
+}
+
+// 与接收者无关的错误(模板id无效、参数错误) 后续发送必然失败 中止整批
+func isFatalErrCode(code int) bool {
+	switch code {
+	case 40037, 47003:
+		return true
+	}
+	return false
+}
+
+// 批量发送模板消息，recipients关闭后等待全部发送完成并返回报告
+// ctx取消时停止发送并返回ctx.Err()，已完成的结果仍会返回
+// 遇到isFatalErrCode错误时中止发送，err为该接口错误，recipients中剩余的接收者
+// 均记为BulkCancelled，因此会读取recipients直到其关闭
+func (t *Trader) BulkSendTemplate(parent context.Context, recipients <-chan TemplateRecipient, opt BulkTemplateOptions) (report BulkReport, err error) {
+	if opt.Workers <= 0 {
+		opt.Workers = 4
+	}
+	if opt.MaxRetries == 0 {
+		opt.MaxRetries = 3
+	}
+	done := make(map[string]BulkResult)
+	if opt.Checkpoint != nil {
+		done, err = opt.Checkpoint.Load()
+		if err != nil {
+			return
+		}
+	}
+	l := newLimiter(opt.Rate, opt.Burst)
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	aborted := false
+
+	var (
+		mtx sync.Mutex
+		wg  sync.WaitGroup
+	)
+	collect := func(res BulkResult) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		report.add(res)
+		if res.Status == BulkFailed && isFatalErrCode(res.ErrCode) && !aborted {
+			aborted = true
+			if err == nil {
+				err = errors.New(res.Error)
+			}
+			cancel()
+		}
+		if opt.Checkpoint != nil && !res.Resumed {
+			if e := opt.Checkpoint.Save(res); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	cancelled := func(r TemplateRecipient) {
+		collect(BulkResult{OpenId: r.OpenId, Status: BulkCancelled})
+	}
+
+	jobs := make(chan TemplateRecipient)
+	for i := 0; i < opt.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				collect(t.sendTemplateWithRetry(ctx, l, opt, r))
+			}
+		}()
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case r, ok := <-recipients:
+			if !ok {
+				break loop
+			}
+			if res, ok := done[r.OpenId]; ok && bulkDone(res) {
+				res.Resumed = true
+				collect(res)
+				continue
+			}
+			select {
+			case jobs <- r:
+			case <-ctx.Done():
+				cancelled(r)
+				break loop
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	mtx.Lock()
+	drain := aborted
+	mtx.Unlock()
+	if drain {
+	rest:
+		for {
+			select {
+			case <-parent.Done():
+				break rest
+			case r, ok := <-recipients:
+				if !ok {
+					break rest
+				}
+				cancelled(r)
+			}
+		}
+	}
+	if err == nil {
+		err = parent.Err()
+	}
+	return
+}
+
+func (t *Trader) sendTemplateWithRetry(ctx context.Context, l *limiter, opt BulkTemplateOptions, r TemplateRecipient) (res BulkResult) {
+	res.OpenId = r.OpenId
+	msg := TemplateMsg{
+		ToUser:      r.OpenId,
+		TemplateId:  opt.TemplateId,
+		Url:         r.Url,
+		Miniprogram: r.Miniprogram,
+		Data:        r.Data,
+	}
+	for {
+		if err := l.Wait(ctx); err != nil {
+			if res.Attempts == 0 {
+				res.Status = BulkCancelled
+			} else {
+				res.Status = BulkFailed
+			}
+			return
+		}
+		res.Attempts++
+		msgid, err := t.SendTemplate(msg)
+		if err == nil {
+			res.Status, res.MsgId, res.ErrCode, res.Error = BulkSent, msgid, 0, ""
+			return
+		}
+		res.ErrCode, res.Error = ErrCode(err), err.Error()
+		if isFatalErrCode(res.ErrCode) {
+			res.Status = BulkFailed
+			return
+		}
+		if isPermanentErrCode(res.ErrCode) {
+			res.Status = BulkSkipped
+			return
+		}
+		if !isTransientError(err) || res.Attempts > opt.MaxRetries {
+			res.Status = BulkFailed
+			return
+		}
+		if isTokenErrCode(res.ErrCode) {
+			t.FlushAccessToken()
+		}
+		timer := time.NewTimer(time.Duration(res.Attempts) * 500 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			res.Status = BulkFailed
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// 以JSON Lines格式记录进度的文件
+type FileCheckpoint struct {
+	Path string
+	mtx  sync.Mutex
+}
+
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{Path: path}
+}
+
+func (f *FileCheckpoint) Load() (done map[string]BulkResult, err error) {
+	done = make(map[string]BulkResult)
+	fd, err := os.Open(f.Path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	s := bufio.NewScanner(fd)
+	for s.Scan() {
+		var res BulkResult
+		if json.Unmarshal(s.Bytes(), &res) != nil {
+			continue
+		}
+		done[res.OpenId] = res
+	}
+	err = s.Err()
+	return
+}
+
+func (f *FileCheckpoint) Save(res BulkResult) (err error) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	fd, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	_, err = fd.Write(append(b, '\n'))
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	return
+}
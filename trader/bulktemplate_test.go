@@ -0,0 +1,237 @@
+package trader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"system busy", errors.New(`{"errcode":-1,"errmsg":"system error"}`), true},
+		{"rate limited", errors.New(`{"errcode":45011,"errmsg":"api minute-quota reach limit"}`), true},
+		{"token expired", errors.New(`{"errcode":42001,"errmsg":"access_token expired"}`), true},
+		{"unsubscribed", errors.New(`{"errcode":43004,"errmsg":"require subscribe"}`), false},
+		{"invalid template", errors.New(`{"errcode":40037,"errmsg":"invalid template_id"}`), false},
+		{"timeout", &url.Error{Op: "Post", URL: "https://api.weixin.qq.com", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"cancelled", context.Canceled, false},
+		{"deadline in url error", &url.Error{Op: "Post", URL: "https://api.weixin.qq.com", Err: context.DeadlineExceeded}, false},
+		{"marshal", &json.UnsupportedValueError{Str: "NaN"}, false},
+		{"plain", errors.New("invalid recipient"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("%s: isTransientError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// 按touser返回预设结果的模板消息接口
+type templateStub struct {
+	mtx   sync.Mutex
+	calls map[string]int
+	reply func(touser string, call int, w http.ResponseWriter)
+}
+
+func (s *templateStub) serve(w http.ResponseWriter, r *http.Request) {
+	var msg TemplateMsg
+	json.NewDecoder(r.Body).Decode(&msg)
+	s.mtx.Lock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[msg.ToUser]++
+	n := s.calls[msg.ToUser]
+	s.mtx.Unlock()
+	s.reply(msg.ToUser, n, w)
+}
+
+func (s *templateStub) total() (n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, c := range s.calls {
+		n += c
+	}
+	return
+}
+
+func sendOK(w http.ResponseWriter, msgid int) {
+	fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","msgid":%d}`, msgid)
+}
+
+func recipients(ids ...string) <-chan TemplateRecipient {
+	ch := make(chan TemplateRecipient, len(ids))
+	for _, id := range ids {
+		ch <- TemplateRecipient{OpenId: id}
+	}
+	close(ch)
+	return ch
+}
+
+func resultsByOpenId(report BulkReport) map[string]BulkResult {
+	m := make(map[string]BulkResult)
+	for _, r := range report.Results {
+		m[r.OpenId] = r
+	}
+	return m
+}
+
+func TestBulkSendTemplate(t *testing.T) {
+	stub := &templateStub{reply: func(touser string, call int, w http.ResponseWriter) {
+		switch touser {
+		case "busy":
+			if call == 1 {
+				fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+				return
+			}
+		case "flaky":
+			if call == 1 {
+				// 直接断开连接
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+		case "gone":
+			fmt.Fprint(w, `{"errcode":43004,"errmsg":"require subscribe"}`)
+			return
+		case "bad":
+			fmt.Fprint(w, `{"errcode":40003,"errmsg":"invalid openid"}`)
+			return
+		}
+		sendOK(w, 100+call)
+	}}
+	tr := newStubTrader(t, stub.serve)
+
+	report, err := tr.BulkSendTemplate(context.Background(), recipients("ok", "busy", "flaky", "gone", "bad"), BulkTemplateOptions{TemplateId: "tpl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 5 || report.Sent != 3 || report.Skipped != 2 || report.Failed != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	want := map[string]struct {
+		status   BulkStatus
+		attempts int
+	}{
+		"ok":    {BulkSent, 1},
+		"busy":  {BulkSent, 2},
+		"flaky": {BulkSent, 2},
+		"gone":  {BulkSkipped, 1},
+		"bad":   {BulkSkipped, 1},
+	}
+	for id, res := range resultsByOpenId(report) {
+		if w := want[id]; res.Status != w.status || res.Attempts != w.attempts {
+			t.Errorf("%s: got %s after %d attempts, want %s after %d", id, res.Status, res.Attempts, w.status, w.attempts)
+		}
+	}
+}
+
+func TestBulkSendTemplateNoRetry(t *testing.T) {
+	stub := &templateStub{reply: func(touser string, call int, w http.ResponseWriter) {
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+	}}
+	tr := newStubTrader(t, stub.serve)
+
+	report, err := tr.BulkSendTemplate(context.Background(), recipients("a"), BulkTemplateOptions{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := report.Results[0]; res.Status != BulkFailed || res.Attempts != 1 || res.ErrCode != -1 {
+		t.Fatalf("result = %+v", res)
+	}
+}
+
+func TestBulkSendTemplateFatal(t *testing.T) {
+	stub := &templateStub{reply: func(touser string, call int, w http.ResponseWriter) {
+		fmt.Fprint(w, `{"errcode":40037,"errmsg":"invalid template_id"}`)
+	}}
+	tr := newStubTrader(t, stub.serve)
+
+	ids := []string{"a", "b", "c", "d", "e"}
+	report, err := tr.BulkSendTemplate(context.Background(), recipients(ids...), BulkTemplateOptions{Workers: 1})
+	if ErrCode(err) != 40037 {
+		t.Fatalf("err = %v, want errcode 40037", err)
+	}
+	if stub.total() != 1 {
+		t.Errorf("%d requests after fatal error, want 1", stub.total())
+	}
+	if report.Total != len(ids) || report.Failed != 1 || report.Cancelled != len(ids)-1 {
+		t.Fatalf("report = %+v", report)
+	}
+	got := resultsByOpenId(report)
+	for _, id := range ids {
+		if _, ok := got[id]; !ok {
+			t.Errorf("no result for %s", id)
+		}
+	}
+}
+
+func TestBulkSendTemplateResume(t *testing.T) {
+	stub := &templateStub{reply: func(touser string, call int, w http.ResponseWriter) {
+		sendOK(w, 1)
+	}}
+	tr := newStubTrader(t, stub.serve)
+
+	cp := NewFileCheckpoint(filepath.Join(t.TempDir(), "progress.jsonl"))
+	for _, res := range []BulkResult{
+		{OpenId: "sent", Status: BulkSent, MsgId: 7, Attempts: 1},
+		{OpenId: "skipped", Status: BulkSkipped, ErrCode: 43004, Attempts: 1},
+		{OpenId: "failed", Status: BulkFailed, ErrCode: -1, Attempts: 4},
+		{OpenId: "cancelled", Status: BulkCancelled},
+	} {
+		if err := cp.Save(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := tr.BulkSendTemplate(context.Background(), recipients("sent", "skipped", "failed", "cancelled", "new"), BulkTemplateOptions{Checkpoint: cp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != 2 || report.Sent != 4 || report.Skipped != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	for _, id := range []string{"sent", "skipped"} {
+		if stub.calls[id] != 0 {
+			t.Errorf("%s was sent again", id)
+		}
+	}
+	for _, id := range []string{"failed", "cancelled", "new"} {
+		if stub.calls[id] != 1 {
+			t.Errorf("%s sent %d times, want 1", id, stub.calls[id])
+		}
+	}
+
+	done, err := cp.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"failed", "cancelled", "new"} {
+		if done[id].Status != BulkSent {
+			t.Errorf("checkpoint %s = %+v, want sent", id, done[id])
+		}
+	}
+	if done["sent"].MsgId != 7 {
+		t.Errorf("resumed result overwritten: %+v", done["sent"])
+	}
+}
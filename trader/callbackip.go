@@ -0,0 +1,48 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/*
+  微信服务器IP
+  GetCallbackIP返回微信推送消息时使用的IP(段)，GetApiDomainIP返回api.weixin.qq.com解析出的IP
+*/
+
+func (t *Trader) getIPList(action string) (list []string, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/" + action + "?access_token=" + t.Accesstoken
+	b, err := t.Get(surl)
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode int      `json:"errcode"`
+		ErrMsg  string   `json:"errmsg"`
+		IPList  []string `json:"ip_list"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	list = r.IPList
+	return
+}
+
+// 获取微信callback服务器的IP地址 可能为单个IP或CIDR网段
+func (t *Trader) GetCallbackIP() ([]string, error) {
+	return t.getIPList("getcallbackip")
+}
+
+// 获取微信API接口的IP地址
+func (t *Trader) GetApiDomainIP() ([]string, error) {
+	return t.getIPList("get_api_domain_ip")
+}
@@ -0,0 +1,181 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/*
+  公众号迁移后的openid转换
+*/
+
+// 单次转换openid的上限
+const ChangeOpenIdLimit = 100
+
+type OpenIdMapping struct {
+	OriOpenId string `json:"ori_openid"`
+	NewOpenId string `json:"new_openid"`
+	ErrMsg    string `json:"err_msg,omitempty"`
+}
+
+func (m OpenIdMapping) Ok() bool {
+	return m.NewOpenId != "" && (m.ErrMsg == "" || m.ErrMsg == "ok")
+}
+
+// 将原账号(fromAppId)的openid转换为当前账号的openid 超过100个时自动拆分
+// 单个openid转换失败时记录在对应OpenIdMapping的ErrMsg中，接口调用失败时返回*BatchError
+func (t *Trader) ChangeOpenID(fromAppId string, openids []string) (list []OpenIdMapping, err error) {
+	err = t.batchChunks(openids, ChangeOpenIdLimit, func(ids []string) error {
+		l, e := t.changeOpenID(fromAppId, ids)
+		list = append(list, l...)
+		return e
+	})
+	return
+}
+
+func (t *Trader) changeOpenID(fromAppId string, openids []string) (list []OpenIdMapping, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/changeopenid?access_token=" + t.Accesstoken
+	var p struct {
+		FromAppId  string   `json:"from_appid"`
+		OpenIdList []string `json:"openid_list"`
+	}
+	p.FromAppId, p.OpenIdList = fromAppId, openids
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode    int             `json:"errcode"`
+		ErrMsg     string          `json:"errmsg"`
+		ResultList []OpenIdMapping `json:"result_list"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	list = r.ResultList
+	return
+}
+
+// 逐页提供openid OpenIdIterator满足该接口
+type OpenIdSource interface {
+	Next() bool
+	Page() []string
+	Err() error
+}
+
+type sliceSource struct {
+	ids  []string
+	page []string
+}
+
+// 以切片作为OpenIdSource 每页最多10000个
+func SliceOpenIdSource(openids []string) OpenIdSource {
+	return &sliceSource{ids: openids}
+}
+
+func (s *sliceSource) Next() bool {
+	if len(s.ids) == 0 {
+		s.page = nil
+		return false
+	}
+	n := 10000
+	if n > len(s.ids) {
+		n = len(s.ids)
+	}
+	s.page, s.ids = s.ids[:n], s.ids[n:]
+	return true
+}
+
+func (s *sliceSource) Page() []string {
+	return s.page
+}
+
+func (s *sliceSource) Err() error {
+	return nil
+}
+
+// 接收转换成功的openid对应关系，如写入数据库
+type OpenIdMappingSink interface {
+	Write(list []OpenIdMapping) error
+}
+
+type OpenIdMappingSinkFunc func(list []OpenIdMapping) error
+
+func (f OpenIdMappingSinkFunc) Write(list []OpenIdMapping) error {
+	return f(list)
+}
+
+type OpenIdMigrationReport struct {
+	Total    int
+	Migrated int
+	// 微信返回转换失败的openid
+	Failures []OpenIdMapping
+	// 调用接口失败的分片，其中的openid未转换
+	Errors []ChunkError
+	// src中已处理完的openid数(含跳过的start个)，中断后作为start重新调用即可继续
+	Offset int
+}
+
+// 从src读取原账号的openid，跳过前start个后逐批转换，将成功的对应关系写入sink
+// sink写入失败时立即返回，report.Offset为该批之前已处理的数量
+func (t *Trader) MigrateOpenIds(fromAppId string, src OpenIdSource, sink OpenIdMappingSink, start int) (report OpenIdMigrationReport, err error) {
+	offset := 0
+	for src.Next() {
+		page := src.Page()
+		if offset+len(page) <= start {
+			offset += len(page)
+			report.Offset = offset
+			continue
+		}
+		if offset < start {
+			page, offset = page[start-offset:], start
+		}
+		for _, ids := range chunkStrings(page, ChangeOpenIdLimit) {
+			report.Total += len(ids)
+			list, e := t.changeOpenID(fromAppId, ids)
+			if e != nil {
+				report.Errors = append(report.Errors, ChunkError{
+					Start:   offset,
+					End:     offset + len(ids),
+					OpenIds: ids,
+					Err:     e,
+				})
+				offset += len(ids)
+				report.Offset = offset
+				continue
+			}
+			ok := make([]OpenIdMapping, 0, len(list))
+			for _, m := range list {
+				if m.Ok() {
+					ok = append(ok, m)
+				} else {
+					report.Failures = append(report.Failures, m)
+				}
+			}
+			if len(ok) > 0 {
+				err = sink.Write(ok)
+				if err != nil {
+					return
+				}
+				report.Migrated += len(ok)
+			}
+			offset += len(ids)
+			report.Offset = offset
+		}
+	}
+	err = src.Err()
+	return
+}
@@ -0,0 +1,59 @@
+package trader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 单个分片的失败信息 Start、End为分片在原列表中的下标范围[Start,End)
+type ChunkError struct {
+	Start   int
+	End     int
+	OpenIds []string
+	Err     error
+}
+
+// 分片调用接口时部分分片失败，未列出的分片均已成功
+type BatchError struct {
+	Total  int
+	Chunks []ChunkError
+}
+
+func (e *BatchError) Error() string {
+	s := make([]string, len(e.Chunks))
+	for i, c := range e.Chunks {
+		s[i] = fmt.Sprintf("[%d,%d): %v", c.Start, c.End, c.Err)
+	}
+	return fmt.Sprintf("%d of %d chunks failed: %s", len(e.Chunks), e.Total, strings.Join(s, "; "))
+}
+
+func chunkStrings(s []string, size int) (chunks [][]string) {
+	for i := 0; i < len(s); i += size {
+		end := i + size
+		if end > len(s) {
+			end = len(s)
+		}
+		chunks = append(chunks, s[i:end])
+	}
+	return
+}
+
+// 将openids按size拆分后依次调用fn，某个分片失败不影响后续分片
+func (t *Trader) batchChunks(openids []string, size int, fn func(ids []string) error) error {
+	var be BatchError
+	for i, ids := range chunkStrings(openids, size) {
+		be.Total++
+		if err := fn(ids); err != nil {
+			be.Chunks = append(be.Chunks, ChunkError{
+				Start:   i * size,
+				End:     i*size + len(ids),
+				OpenIds: ids,
+				Err:     err,
+			})
+		}
+	}
+	if len(be.Chunks) > 0 {
+		return &be
+	}
+	return nil
+}
@@ -107,7 +107,7 @@ func (t *Trader) MarkelectComment(msgdataid, index, usercommentid int) (err erro
 	var p struct {
 		MsgDataId     int `json:"msg_data_id"`
 		Index         int `json:"index"`
-		UserCommentID int `json:"user_comment_id "`
+		UserCommentID int `json:"user_comment_id"`
 	}
 	p.MsgDataId, p.Index, p.UserCommentID = msgdataid, index, usercommentid
 	str, err := json.Marshal(p)
@@ -139,7 +139,7 @@ func (t *Trader) UnMarkelectComment(msgdataid, index, usercommentid int) (err er
 	var p struct {
 		MsgDataId     int `json:"msg_data_id"`
 		Index         int `json:"index"`
-		UserCommentID int `json:"user_comment_id "`
+		UserCommentID int `json:"user_comment_id"`
 	}
 	p.MsgDataId, p.Index, p.UserCommentID = msgdataid, index, usercommentid
 	str, err := json.Marshal(p)
@@ -171,7 +171,7 @@ func (t *Trader) DeleteComment(msgdataid, index, usercommentid int) (err error)
 	var p struct {
 		MsgDataId     int `json:"msg_data_id"`
 		Index         int `json:"index"`
-		UserCommentID int `json:"user_comment_id "`
+		UserCommentID int `json:"user_comment_id"`
 	}
 	p.MsgDataId, p.Index, p.UserCommentID = msgdataid, index, usercommentid
 	str, err := json.Marshal(p)
@@ -203,7 +203,7 @@ func (t *Trader) ReplyComment(msgdataid, index, usercommentid int, content strin
 	var p struct {
 		MsgDataId     int    `json:"msg_data_id"`
 		Index         int    `json:"index"`
-		UserCommentID int    `json:"user_comment_id "`
+		UserCommentID int    `json:"user_comment_id"`
 		Content       string `json:"content"`
 	}
 	p.MsgDataId, p.Index, p.UserCommentID = msgdataid, index, usercommentid
@@ -237,7 +237,7 @@ func (t *Trader) DeleteReplyComment(msgdataid, index, usercommentid int) (err er
 	var p struct {
 		MsgDataId     int `json:"msg_data_id"`
 		Index         int `json:"index"`
-		UserCommentID int `json:"user_comment_id "`
+		UserCommentID int `json:"user_comment_id"`
 	}
 	p.MsgDataId, p.Index, p.UserCommentID = msgdataid, index, usercommentid
 	str, err := json.Marshal(p)
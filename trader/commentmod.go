@@ -0,0 +1,193 @@
+package trader
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+  评论审核
+  拉取指定文章的全部评论，依次交给规则判断，按第一个命中的规则执行精选、取消精选、删除或回复
+*/
+
+type CommentAction int
+
+const (
+	CommentKeep CommentAction = iota
+	CommentElect
+	CommentUnelect
+	CommentDelete
+	CommentReply
+)
+
+func (a CommentAction) String() string {
+	switch a {
+	case CommentElect:
+		return "elect"
+	case CommentUnelect:
+		return "unelect"
+	case CommentDelete:
+		return "delete"
+	case CommentReply:
+		return "reply"
+	}
+	return "keep"
+}
+
+// 规则的判断结果 Reply仅在Action为CommentReply时使用
+type CommentDecision struct {
+	Action CommentAction
+	Reply  string
+	Rule   string //规则名称，记录在审核日志中
+}
+
+// 审核规则 ok为false表示未命中，继续判断下一条规则
+type CommentRule func(c Comment) (d CommentDecision, ok bool)
+
+// 评论包含任一关键词时删除
+func KeywordBlockRule(keywords ...string) CommentRule {
+	return KeywordRule(CommentDecision{Action: CommentDelete, Rule: "keyword"}, keywords...)
+}
+
+// 评论包含任一关键词时返回d
+func KeywordRule(d CommentDecision, keywords ...string) CommentRule {
+	return func(c Comment) (CommentDecision, bool) {
+		for _, k := range keywords {
+			if k != "" && strings.Contains(c.Content, k) {
+				return d, true
+			}
+		}
+		return d, false
+	}
+}
+
+// 评论匹配正则时返回d
+func RegexpRule(re *regexp.Regexp, d CommentDecision) CommentRule {
+	return func(c Comment) (CommentDecision, bool) {
+		return d, re.MatchString(c.Content)
+	}
+}
+
+// 审核日志
+type CommentAudit struct {
+	Time          time.Time
+	MsgDataId     int
+	Index         int
+	UserCommentID int
+	OpenID        string
+	Content       string
+	Action        CommentAction
+	Rule          string
+	Reply         string
+	DryRun        bool
+	Err           error
+}
+
+type CommentModerator struct {
+	t     *Trader
+	Rules []CommentRule
+	// 为true时只记录审核结果，不调用接口
+	DryRun bool
+	// 每条执行(或模拟执行)的操作都会调用
+	Audit func(a CommentAudit)
+	// 每页拉取数量，最大49
+	PageSize int
+}
+
+func (t *Trader) NewCommentModerator(rules ...CommentRule) *CommentModerator {
+	return &CommentModerator{
+		t:        t,
+		Rules:    rules,
+		PageSize: 49,
+	}
+}
+
+// 拉取文章的全部评论，commenttype同GetCommentList
+func (t *Trader) GetAllComments(msgdataid, index, pagesize, commenttype int) (all []Comment, err error) {
+	if pagesize <= 0 || pagesize >= 50 {
+		pagesize = 49
+	}
+	for begin := 0; ; begin += pagesize {
+		var list []Comment
+		list, err = t.GetCommentList(msgdataid, index, begin, pagesize, commenttype)
+		if err != nil {
+			return
+		}
+		all = append(all, list...)
+		if len(list) < pagesize {
+			return
+		}
+	}
+}
+
+// 审核文章的全部评论 返回所有非CommentKeep的操作记录
+// 先拉取全部评论再执行操作，避免删除评论导致分页偏移
+func (m *CommentModerator) Run(msgdataid, index int) (audits []CommentAudit, err error) {
+	comments, err := m.t.GetAllComments(msgdataid, index, m.PageSize, 0)
+	if err != nil {
+		return
+	}
+	for _, c := range comments {
+		d, ok := m.decide(c)
+		if !ok {
+			continue
+		}
+		a := CommentAudit{
+			Time:          time.Now(),
+			MsgDataId:     msgdataid,
+			Index:         index,
+			UserCommentID: c.UserCommentID,
+			OpenID:        c.OpenID,
+			Content:       c.Content,
+			Action:        d.Action,
+			Rule:          d.Rule,
+			Reply:         d.Reply,
+			DryRun:        m.DryRun,
+		}
+		if !m.DryRun {
+			a.Err = m.apply(msgdataid, index, c, d)
+		}
+		if m.Audit != nil {
+			m.Audit(a)
+		}
+		audits = append(audits, a)
+	}
+	return
+}
+
+// 返回第一个命中规则的结果，已处于目标状态的评论视为未命中
+func (m *CommentModerator) decide(c Comment) (d CommentDecision, ok bool) {
+	for _, rule := range m.Rules {
+		d, ok = rule(c)
+		if !ok {
+			continue
+		}
+		switch d.Action {
+		case CommentKeep:
+			return d, false
+		case CommentElect:
+			return d, c.CommentType != 1
+		case CommentUnelect:
+			return d, c.CommentType == 1
+		case CommentReply:
+			return d, c.Reply.Content == "" && d.Reply != ""
+		}
+		return d, true
+	}
+	return d, false
+}
+
+func (m *CommentModerator) apply(msgdataid, index int, c Comment, d CommentDecision) error {
+	switch d.Action {
+	case CommentElect:
+		return m.t.MarkelectComment(msgdataid, index, c.UserCommentID)
+	case CommentUnelect:
+		return m.t.UnMarkelectComment(msgdataid, index, c.UserCommentID)
+	case CommentDelete:
+		return m.t.DeleteComment(msgdataid, index, c.UserCommentID)
+	case CommentReply:
+		return m.t.ReplyComment(msgdataid, index, c.UserCommentID, d.Reply)
+	}
+	return nil
+}
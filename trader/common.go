@@ -74,6 +74,16 @@ type (
 		PagePath     string `json:"pagepath"`
 		ThumbMediaId string `json:"thumb_media_id"`
 	}
+	//菜单消息 用户点击后以文本消息回调，bizmsgmenuid为所点菜单项的Id
+	MsgMenu struct {
+		HeadContent string        `json:"head_content"`
+		List        []MsgMenuItem `json:"list"`
+		TailContent string        `json:"tail_content"`
+	}
+	MsgMenuItem struct {
+		Id      string `json:"id"`
+		Content string `json:"content"`
+	}
 )
 
 type (
@@ -132,6 +142,66 @@ type (
 	}
 )
 
+//客服消息 只能设置其中一种内容，用NewCustomXXX构造
+type CustomMessage struct {
+	MsgType         string           `json:"msgtype"`
+	Text            *Text            `json:"text,omitempty"`
+	Image           *Image           `json:"image,omitempty"`
+	Voice           *Voice           `json:"voice,omitempty"`
+	Video           *Video           `json:"video,omitempty"`
+	Music           *Music           `json:"music,omitempty"`
+	News            *News            `json:"news,omitempty"`
+	MpNews          *MpNews          `json:"mpnews,omitempty"`
+	WxCard          *WxCard          `json:"wxcard,omitempty"`
+	Miniprogrampage *Miniprogrampage `json:"miniprogrampage,omitempty"`
+	MsgMenu         *MsgMenu         `json:"msgmenu,omitempty"`
+}
+
+func NewCustomText(content string) CustomMessage {
+	return CustomMessage{MsgType: textType, Text: &Text{Content: content}}
+}
+
+func NewCustomImage(mediaId string) CustomMessage {
+	return CustomMessage{MsgType: imageType, Image: &Image{MediaId: mediaId}}
+}
+
+func NewCustomVoice(mediaId string) CustomMessage {
+	return CustomMessage{MsgType: voiceType, Voice: &Voice{MediaId: mediaId}}
+}
+
+func NewCustomVideo(v Video) CustomMessage {
+	return CustomMessage{MsgType: videoType, Video: &v}
+}
+
+func NewCustomMusic(m Music) CustomMessage {
+	return CustomMessage{MsgType: musicType, Music: &m}
+}
+
+func NewCustomNews(articles ...Article) CustomMessage {
+	return CustomMessage{MsgType: newsType, News: &News{Articles: articles}}
+}
+
+func NewCustomMpNews(mediaId string) CustomMessage {
+	return CustomMessage{MsgType: mpnewsType, MpNews: &MpNews{MediaId: mediaId}}
+}
+
+func NewCustomWxCard(cardId string) CustomMessage {
+	return CustomMessage{MsgType: wxcardType, WxCard: &WxCard{CardId: cardId}}
+}
+
+func NewCustomMiniprogrampage(p Miniprogrampage) CustomMessage {
+	return CustomMessage{MsgType: miniprogrampageType, Miniprogrampage: &p}
+}
+
+func NewCustomMsgMenu(head, tail string, items ...MsgMenuItem) CustomMessage {
+	return CustomMessage{MsgType: msgmenuType, MsgMenu: &MsgMenu{HeadContent: head, List: items, TailContent: tail}}
+}
+
+//客服消息发送选项
+type SendCustomOptions struct {
+	KfAccount string //以指定客服账号的身份发送，为空时不指定
+}
+
 type (
 	NewsArticle struct {
 		Title              string `json:"title"`
@@ -153,7 +223,49 @@ type SendAllResp struct {
 	ErrCode   int    `json:"errcode"`
 	ErrMsg    string `json:"errmsg"`
 	MsgId     int    `json:"msg_id"`
-	MsgDataId int    `json:"206227730"`
+	MsgDataId int    `json:"msg_data_id"`
+}
+
+//群发消息 只能设置其中一种内容，用NewMassXXX构造
+type MassMessage struct {
+	MsgType string   `json:"msgtype"`
+	Text    *Text    `json:"text,omitempty"`
+	Image   *Image   `json:"image,omitempty"`
+	Voice   *Voice   `json:"voice,omitempty"`
+	MpVideo *MpVideo `json:"mpvideo,omitempty"`
+	MpNews  *MpNews  `json:"mpnews,omitempty"`
+	WxCard  *WxCard  `json:"wxcard,omitempty"`
+}
+
+func NewMassText(content string) MassMessage {
+	return MassMessage{MsgType: textType, Text: &Text{Content: content}}
+}
+
+func NewMassImage(mediaId string) MassMessage {
+	return MassMessage{MsgType: imageType, Image: &Image{MediaId: mediaId}}
+}
+
+func NewMassVoice(mediaId string) MassMessage {
+	return MassMessage{MsgType: voiceType, Voice: &Voice{MediaId: mediaId}}
+}
+
+//mediaId需为uploadvideo接口返回的media_id
+func NewMassMpVideo(mediaId string) MassMessage {
+	return MassMessage{MsgType: MpVideoType, MpVideo: &MpVideo{MediaId: mediaId}}
+}
+
+func NewMassMpNews(mediaId string) MassMessage {
+	return MassMessage{MsgType: mpnewsType, MpNews: &MpNews{MediaId: mediaId}}
+}
+
+func NewMassWxCard(cardId string) MassMessage {
+	return MassMessage{MsgType: wxcardType, WxCard: &WxCard{CardId: cardId}}
+}
+
+//群发选项
+type SendAllOptions struct {
+	SendIgnoreReprint bool   //图文被判定为转载时是否继续群发
+	ClientMsgId       string //开发者侧群发msgid，24小时内相同clientmsgid只会群发一次，长度不超过64
 }
 
 //群发预览结构体
@@ -196,20 +308,23 @@ type (
 
 //用户信息
 type UserInfo struct {
-	Subscribe     int    `json:"subscribe"`
-	Openid        string `json:"openid"`
-	NickName      string `json:"nickname"`
-	Sex           int    `json:"sex"`
-	Language      string `json:"language"`
-	City          string `json:"city"`
-	Province      string `json:"province"`
-	Country       string `json:"country"`
-	HeadimgUrl    string `json:"headimgurl"`
-	SubscribeTime int64  `json:"subscribe_time"`
-	Unionid       string `json:"unionid"`
-	Remark        string `json:"remark"`
-	Groupid       int    `json:"groupid"`
-	TagidList     []int  `json:"tagid_list"`
+	Subscribe      int    `json:"subscribe"`
+	Openid         string `json:"openid"`
+	NickName       string `json:"nickname"`
+	Sex            int    `json:"sex"`
+	Language       string `json:"language"`
+	City           string `json:"city"`
+	Province       string `json:"province"`
+	Country        string `json:"country"`
+	HeadimgUrl     string `json:"headimgurl"`
+	SubscribeTime  int64  `json:"subscribe_time"`
+	Unionid        string `json:"unionid"`
+	Remark         string `json:"remark"`
+	Groupid        int    `json:"groupid"`
+	TagidList      []int  `json:"tagid_list"`
+	SubscribeScene string `json:"subscribe_scene"`
+	QrScene        int    `json:"qr_scene"`
+	QrSceneStr     string `json:"qr_scene_str"`
 }
 
 type Fans struct {
@@ -223,13 +338,80 @@ type Fans struct {
 
 //评论
 type Comment struct {
-	UserCommentID int    `json:"user_comment_id "`
-	OpenID        string `json:"openid "`
-	CreateTime    int    `json:"create_time "`
-	Content       string `json:"content "`
-	CommentType   int    `json:"comment_type "`
+	UserCommentID int    `json:"user_comment_id"`
+	OpenID        string `json:"openid"`
+	CreateTime    int    `json:"create_time"`
+	Content       string `json:"content"`
+	CommentType   int    `json:"comment_type"`
 	Reply         struct {
-		Content    string `json:"content "`
-		CreateTime int    `json:"create_time "`
-	} `json:"reply "`
+		Content    string `json:"content"`
+		CreateTime int    `json:"create_time"`
+	} `json:"reply"`
 }
+
+//模板消息
+type (
+	TemplateData struct {
+		Value string `json:"value"`
+		Color string `json:"color,omitempty"`
+	}
+	TemplateMiniprogram struct {
+		AppId    string `json:"appid"`
+		PagePath string `json:"pagepath,omitempty"`
+	}
+	TemplateMsg struct {
+		ToUser      string                  `json:"touser"`
+		TemplateId  string                  `json:"template_id"`
+		Url         string                  `json:"url,omitempty"`
+		Miniprogram *TemplateMiniprogram    `json:"miniprogram,omitempty"`
+		Data        map[string]TemplateData `json:"data"`
+	}
+)
+
+//订阅消息
+type (
+	SubscribeCategory struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	PubTemplateTitle struct {
+		Tid        int    `json:"tid"`
+		Title      string `json:"title"`
+		Type       int    `json:"type"`
+		CategoryId string `json:"categoryId"`
+	}
+	PubTemplateKeyword struct {
+		Kid     int    `json:"kid"`
+		Name    string `json:"name"`
+		Example string `json:"example"`
+		Rule    string `json:"rule"`
+	}
+	SubscribeTemplate struct {
+		PriTmplId string `json:"priTmplId"`
+		Title     string `json:"title"`
+		Content   string `json:"content"`
+		Example   string `json:"example"`
+		Type      int    `json:"type"`
+	}
+	SubscribeData struct {
+		Value string `json:"value"`
+	}
+	//长期订阅消息 subscribe/bizsend
+	SubscribeMsg struct {
+		ToUser      string                   `json:"touser"`
+		TemplateId  string                   `json:"template_id"`
+		Page        string                   `json:"page,omitempty"`
+		Miniprogram *TemplateMiniprogram     `json:"miniprogram,omitempty"`
+		Data        map[string]SubscribeData `json:"data"`
+	}
+	//一次性订阅消息 template/subscribe
+	OnceSubscribeMsg struct {
+		ToUser      string                  `json:"touser"`
+		TemplateId  string                  `json:"template_id"`
+		Url         string                  `json:"url,omitempty"`
+		Miniprogram *TemplateMiniprogram    `json:"miniprogram,omitempty"`
+		Scene       int                     `json:"scene"`
+		Title       string                  `json:"title"`
+		Data        map[string]TemplateData `json:"data"`
+	}
+)
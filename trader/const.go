@@ -11,6 +11,7 @@ const (
 	wxcardType          = "wxcard"
 	miniprogrampageType = "miniprogrampage"
 	MpVideoType         = "mpvideo"
+	msgmenuType         = "msgmenu"
 
 	AccessTokenURL         = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid="
 	UploadURL              = "https://api.weixin.qq.com/cgi-bin/material/add_material?access_token="
@@ -21,10 +22,12 @@ const (
 	TypingURL              = "https://api.weixin.qq.com/cgi-bin/message/custom/typing?access_token="
 	KFaccountURL           = "https://api.weixin.qq.com/customservice/kfaccount/"
 	SetKfAccountheadimgURL = "http://api.weixin.qq.com/customservice/kfaccount/uploadheadimg?"
+	KfSessionURL           = "https://api.weixin.qq.com/customservice/kfsession/"
 	GetkfListURL           = "https://api.weixin.qq.com/cgi-bin/customservice/getkflist?access_token="
 	Menu                   = "https://api.weixin.qq.com/cgi-bin/menu/"
 	MediaURL               = "https://api.weixin.qq.com/cgi-bin/media/"
 	SendAllURL             = "https://api.weixin.qq.com/cgi-bin/message/mass/sendall?access_token="
+	SendMassURL            = "https://api.weixin.qq.com/cgi-bin/message/mass/send?access_token="
 	DeleteSendALLURL       = "https://api.weixin.qq.com/cgi-bin/message/mass/delete?access_token="
 	PreviewURL             = "https://api.weixin.qq.com/cgi-bin/message/mass/preview?access_token="
 	SendAllStatusURL       = "https://api.weixin.qq.com/cgi-bin/message/mass/get?access_token="
@@ -32,4 +35,9 @@ const (
 	TemplateURL            = "https://api.weixin.qq.com/cgi-bin/template/"
 	TagsURL                = "https://api.weixin.qq.com/cgi-bin/tags/"
 	CommentURL             = "https://api.weixin.qq.com/cgi-bin/comment/"
+	NewTmplURL             = "https://api.weixin.qq.com/wxaapi/newtmpl/"
+	SubscribeMsgURL        = "https://api.weixin.qq.com/cgi-bin/message/subscribe/"
+	SubscribeConfirmURL    = "https://mp.weixin.qq.com/mp/subscribemsg?action=get_confirm"
+	OAuthAuthorizeURL      = "https://open.weixin.qq.com/connect/oauth2/authorize"
+	SnsURL                 = "https://api.weixin.qq.com/sns/"
 )
@@ -0,0 +1,88 @@
+package trader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+)
+
+/*
+  客服消息
+  SendCustom可发送任意类型的客服消息，并通过SendCustomOptions指定以哪个客服账号的身份发送
+*/
+
+// 发送客服消息
+/*
+	t.SendCustom(openid, NewCustomMsgMenu("您对本次服务是否满意？", "欢迎再次光临",
+		MsgMenuItem{Id: "101", Content: "满意"},
+		MsgMenuItem{Id: "102", Content: "不满意"},
+	), SendCustomOptions{KfAccount: "test1@test"})
+*/
+func (t *Trader) SendCustom(touser string, msg CustomMessage, opt SendCustomOptions) error {
+	var p struct {
+		ToUser string `json:"touser"`
+		CustomMessage
+		Customservice *Customservice `json:"customservice,omitempty"`
+	}
+	p.ToUser, p.CustomMessage = touser, msg
+	if opt.KfAccount != "" {
+		p.Customservice = &Customservice{KFaccount: opt.KfAccount}
+	}
+	return t.sendMsg(p)
+}
+
+// 上传临时素材 有效期3天，mediaType为image、voice、video或thumb
+func (t *Trader) UploadTempMedia(mediaType, filename string, data []byte) (mediaId string, err error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	fw, err := w.CreateFormFile("media", filename)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(fw, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	w.Close()
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := MediaURL + "upload?access_token=" + t.Accesstoken + "&type=" + mediaType
+	b, err := t.PostMultipart(surl, w.FormDataContentType(), buf)
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		MediaId string `json:"media_id"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 || r.MediaId == "" {
+		err = errors.New(string(b))
+		return
+	}
+	mediaId = r.MediaId
+	return
+}
+
+// 发送小程序卡片 thumb不为空时先上传为临时图片素材并替换page.ThumbMediaId，建议尺寸520*416
+func (t *Trader) SendMiniProgramCard(touser string, page Miniprogrampage, thumb []byte, opt SendCustomOptions) (err error) {
+	if len(thumb) > 0 {
+		page.ThumbMediaId, err = t.UploadTempMedia(imageType, "thumb.jpg", thumb)
+		if err != nil {
+			return
+		}
+	}
+	if page.ThumbMediaId == "" {
+		err = errors.New("缺少小程序卡片图片")
+		return
+	}
+	return t.SendCustom(touser, NewCustomMiniprogrampage(page), opt)
+}
@@ -0,0 +1,224 @@
+package trader
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+  关注者导出
+  全量导出将全部关注者写为CSV或JSON Lines，增量导出与上一次的快照比较，只输出变化的记录
+*/
+
+type FollowerChange string
+
+const (
+	FollowerFull         FollowerChange = ""
+	FollowerSubscribed   FollowerChange = "subscribed"
+	FollowerUnsubscribed FollowerChange = "unsubscribed"
+	FollowerChanged      FollowerChange = "changed"
+)
+
+// 导出的一条记录 全量导出时Change为空
+type FollowerRecord struct {
+	Change FollowerChange `json:"change,omitempty"`
+	UserInfo
+}
+
+type FollowerWriter interface {
+	Write(r FollowerRecord) error
+	Flush() error
+}
+
+// 关注者快照 openid -> 用户信息
+type FollowerSnapshot map[string]UserInfo
+
+// 读取JSON Lines格式的快照，即全量导出的JSONL文件
+func LoadFollowerSnapshot(r io.Reader) (s FollowerSnapshot, err error) {
+	s = make(FollowerSnapshot)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec FollowerRecord
+		err = json.Unmarshal(sc.Bytes(), &rec)
+		if err != nil {
+			return
+		}
+		if rec.Change == FollowerUnsubscribed {
+			delete(s, rec.Openid)
+			continue
+		}
+		s[rec.Openid] = rec.UserInfo
+	}
+	err = sc.Err()
+	return
+}
+
+// 将快照写为全量记录
+func (s FollowerSnapshot) WriteTo(w FollowerWriter) (err error) {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		err = w.Write(FollowerRecord{UserInfo: s[id]})
+		if err != nil {
+			return
+		}
+	}
+	return w.Flush()
+}
+
+// 全量导出全部关注者 返回本次的快照
+// 写入失败时立即停止拉取并返回该错误
+func (t *Trader) ExportFollowers(ctx context.Context, w FollowerWriter, workers int) (cur FollowerSnapshot, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cur = make(FollowerSnapshot)
+	s := t.StreamUserInfo(ctx, "", workers)
+	for u := range s.C {
+		cur[u.Openid] = u
+		if err = w.Write(FollowerRecord{UserInfo: u}); err != nil {
+			cancel()
+			s.Wait()
+			return
+		}
+	}
+	_, err = s.Wait()
+	if err != nil {
+		return
+	}
+	err = w.Flush()
+	return
+}
+
+// 增量导出 拉取全部关注者并与prev比较，只写出新关注、取消关注和信息变化的记录
+func (t *Trader) SyncFollowers(ctx context.Context, prev FollowerSnapshot, w FollowerWriter, workers int) (cur FollowerSnapshot, err error) {
+	cur = make(FollowerSnapshot)
+	s := t.StreamUserInfo(ctx, "", workers)
+	for u := range s.C {
+		cur[u.Openid] = u
+	}
+	_, err = s.Wait()
+	if err != nil {
+		return
+	}
+	for _, rec := range DiffFollowers(prev, cur) {
+		err = w.Write(rec)
+		if err != nil {
+			return
+		}
+	}
+	err = w.Flush()
+	return
+}
+
+// 比较两次快照 结果按openid排序
+func DiffFollowers(prev, cur FollowerSnapshot) (changes []FollowerRecord) {
+	for id, u := range cur {
+		old, ok := prev[id]
+		switch {
+		case !ok:
+			changes = append(changes, FollowerRecord{Change: FollowerSubscribed, UserInfo: u})
+		case userInfoChanged(old, u):
+			changes = append(changes, FollowerRecord{Change: FollowerChanged, UserInfo: u})
+		}
+	}
+	for id, u := range prev {
+		if _, ok := cur[id]; !ok {
+			u.Subscribe = 0
+			changes = append(changes, FollowerRecord{Change: FollowerUnsubscribed, UserInfo: u})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Openid < changes[j].Openid
+	})
+	return
+}
+
+func userInfoChanged(a, b UserInfo) bool {
+	a.TagidList, b.TagidList = sortedTags(a.TagidList), sortedTags(b.TagidList)
+	return !reflect.DeepEqual(a, b)
+}
+
+func sortedTags(tags []int) []int {
+	if len(tags) == 0 {
+		return nil
+	}
+	s := append([]int(nil), tags...)
+	sort.Ints(s)
+	return s
+}
+
+type jsonlFollowerWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// JSON Lines格式 每行一个FollowerRecord，可被LoadFollowerSnapshot读取
+func NewJSONLFollowerWriter(w io.Writer) FollowerWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlFollowerWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (j *jsonlFollowerWriter) Write(r FollowerRecord) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlFollowerWriter) Flush() error {
+	return j.w.Flush()
+}
+
+var followerCSVHeader = []string{
+	"change", "openid", "unionid", "subscribe", "subscribe_time", "subscribe_scene",
+	"qr_scene", "qr_scene_str", "nickname", "sex", "language", "city", "province",
+	"country", "remark", "groupid", "tagid_list",
+}
+
+type csvFollowerWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// CSV格式 第一行为表头，tagid_list以分号分隔
+func NewCSVFollowerWriter(w io.Writer) FollowerWriter {
+	return &csvFollowerWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvFollowerWriter) Write(r FollowerRecord) (err error) {
+	if !c.header {
+		err = c.w.Write(followerCSVHeader)
+		if err != nil {
+			return
+		}
+		c.header = true
+	}
+	tags := make([]string, len(r.TagidList))
+	for i, id := range r.TagidList {
+		tags[i] = strconv.Itoa(id)
+	}
+	return c.w.Write([]string{
+		string(r.Change), r.Openid, r.Unionid, strconv.Itoa(r.Subscribe),
+		fmt.Sprint(r.SubscribeTime), r.SubscribeScene, strconv.Itoa(r.QrScene),
+		r.QrSceneStr, r.NickName, strconv.Itoa(r.Sex), r.Language, r.City,
+		r.Province, r.Country, r.Remark, strconv.Itoa(r.Groupid), strings.Join(tags, ";"),
+	})
+}
+
+func (c *csvFollowerWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
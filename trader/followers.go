@@ -0,0 +1,245 @@
+package trader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+/*
+  关注者遍历
+  FansIterator按next_openid逐页拉取关注者openid，BatchGetUserInfo批量获取用户信息，
+  StreamUserInfo将两者组合为并发拉取的UserInfo流
+*/
+
+// 批量获取用户信息的单次上限
+const BatchUserInfoLimit = 100
+
+// 按next_openid逐页遍历openid列表 关注者、标签下粉丝、黑名单共用
+/*
+	it := t.FansIterator("")
+	for it.Next() {
+		for _, openid := range it.Page() { ... }
+		save(it.Cursor())
+	}
+	if err := it.Err(); err != nil { ... }
+*/
+type OpenIdIterator struct {
+	fetch  func(cursor string) (ids []string, next string, total int, err error)
+	cursor string
+	page   []string
+	total  int
+	done   bool
+	err    error
+}
+
+func newOpenIdIterator(cursor string, fetch func(cursor string) ([]string, string, int, error)) *OpenIdIterator {
+	return &OpenIdIterator{fetch: fetch, cursor: cursor}
+}
+
+// 逐页遍历关注者 nextopenid为空时从头开始，传入之前保存的Cursor可从中断处继续
+func (t *Trader) FansIterator(nextopenid string) *OpenIdIterator {
+	return newOpenIdIterator(nextopenid, func(cursor string) ([]string, string, int, error) {
+		fans, err := t.GetFans(cursor)
+		return fans.Data.OpenId, fans.NextOpenId, fans.Total, err
+	})
+}
+
+// 拉取下一页，没有更多数据或出错时返回false
+func (it *OpenIdIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	ids, next, total, err := it.fetch(it.cursor)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.total = total
+	if len(ids) == 0 {
+		it.done = true
+		it.page = nil
+		return false
+	}
+	it.page = ids
+	if next == "" || next == it.cursor {
+		it.done = true
+	} else {
+		it.cursor = next
+	}
+	return true
+}
+
+// 当前页的openid
+func (it *OpenIdIterator) Page() []string {
+	return it.page
+}
+
+// 当前页之后继续拉取所用的next_openid，处理完当前页后保存即可断点续传
+func (it *OpenIdIterator) Cursor() string {
+	return it.cursor
+}
+
+// 总数 接口不返回总数时为0
+func (it *OpenIdIterator) Total() int {
+	return it.total
+}
+
+func (it *OpenIdIterator) Err() error {
+	return it.err
+}
+
+// 批量获取用户基本信息 超过100个时自动拆分
+func (t *Trader) BatchGetUserInfo(openids []string) (users []UserInfo, err error) {
+	for i := 0; i < len(openids); i += BatchUserInfoLimit {
+		end := i + BatchUserInfoLimit
+		if end > len(openids) {
+			end = len(openids)
+		}
+		var list []UserInfo
+		list, err = t.batchGetUserInfo(openids[i:end])
+		if err != nil {
+			return
+		}
+		users = append(users, list...)
+	}
+	return
+}
+
+func (t *Trader) batchGetUserInfo(openids []string) (users []UserInfo, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/user/info/batchget?access_token=" + t.Accesstoken
+	type user struct {
+		OpenId string `json:"openid"`
+		Lang   string `json:"lang"`
+	}
+	var p struct {
+		UserList []user `json:"user_list"`
+	}
+	for _, id := range openids {
+		p.UserList = append(p.UserList, user{OpenId: id, Lang: "zh_CN"})
+	}
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode      int        `json:"errcode"`
+		ErrMsg       string     `json:"errmsg"`
+		UserInfoList []UserInfo `json:"user_info_list"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	users = r.UserInfoList
+	return
+}
+
+// 关注者信息流 从C读取直到关闭，然后调用Wait获取断点和错误
+type UserInfoStream struct {
+	C <-chan UserInfo
+
+	done   chan struct{}
+	cursor string
+	err    error
+}
+
+// 等待拉取结束 cursor为最后一个完整输出的页之后的next_openid，可用于断点续传
+func (s *UserInfoStream) Wait() (cursor string, err error) {
+	<-s.done
+	return s.cursor, s.err
+}
+
+// 从nextopenid开始遍历全部关注者并以workers个并发批量拉取用户信息
+// 同一页内的用户信息输出顺序不固定，一页全部输出后才推进断点
+func (t *Trader) StreamUserInfo(ctx context.Context, nextopenid string, workers int) *UserInfoStream {
+	if workers <= 0 {
+		workers = 4
+	}
+	out := make(chan UserInfo)
+	s := &UserInfoStream{C: out, done: make(chan struct{}), cursor: nextopenid}
+
+	go func() {
+		defer close(s.done)
+		defer close(out)
+
+		it := t.FansIterator(nextopenid)
+		for it.Next() {
+			if err := t.streamPage(ctx, it.Page(), workers, out); err != nil {
+				s.err = err
+				return
+			}
+			s.cursor = it.Cursor()
+		}
+		s.err = it.Err()
+	}()
+	return s
+}
+
+func (t *Trader) streamPage(ctx context.Context, page []string, workers int, out chan<- UserInfo) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan []string)
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+	fail := func(e error) {
+		once.Do(func() {
+			err = e
+			cancel()
+		})
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ids := range chunks {
+				users, e := t.batchGetUserInfo(ids)
+				if e != nil {
+					fail(e)
+					return
+				}
+				for _, u := range users {
+					select {
+					case out <- u:
+					case <-ctx.Done():
+						fail(ctx.Err())
+						return
+					}
+				}
+			}
+		}()
+	}
+
+send:
+	for i := 0; i < len(page); i += BatchUserInfoLimit {
+		end := i + BatchUserInfoLimit
+		if end > len(page) {
+			end = len(page)
+		}
+		select {
+		case chunks <- page[i:end]:
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break send
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	return
+}
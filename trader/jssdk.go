@@ -0,0 +1,183 @@
+package trader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  JS-SDK
+  JSSDKHandler返回wx.config所需参数，CardSign系列用于chooseCard、addCard的卡券签名
+*/
+
+// 卡券签名 将全部参数按字典序排序后拼接再sha1
+func CardSign(values ...string) string {
+	s := append([]string(nil), values...)
+	sort.Strings(s)
+	return sha(strings.Join(s, ""))
+}
+
+// wx.chooseCard的参数
+type ChooseCardConf struct {
+	ShopId    string `json:"shopId"`
+	CardType  string `json:"cardType"`
+	CardId    string `json:"cardId"`
+	TimeStamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	SignType  string `json:"signType"`
+	CardSign  string `json:"cardSign"`
+}
+
+// 生成wx.chooseCard的参数 shopId、cardType、cardId可为空
+func (t *Trader) ChooseCardConfig(shopId, cardType, cardId string) (c ChooseCardConf, err error) {
+	ticket, err := t.GetWxCardTicket()
+	if err != nil {
+		return
+	}
+	c = ChooseCardConf{
+		ShopId:    shopId,
+		CardType:  cardType,
+		CardId:    cardId,
+		TimeStamp: time.Now().Unix(),
+		NonceStr:  GetRandStr(16),
+		SignType:  "SHA1",
+	}
+	c.CardSign = CardSign(ticket, t.AppId, shopId, fmt.Sprint(c.TimeStamp), c.NonceStr, cardId, cardType)
+	return
+}
+
+// wx.addCard中cardList每一项的cardExt
+type CardExt struct {
+	Code      string `json:"code,omitempty"`
+	OpenId    string `json:"openid,omitempty"`
+	Timestamp string `json:"timestamp"`
+	NonceStr  string `json:"nonce_str"`
+	OuterStr  string `json:"outer_str,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// 生成wx.addCard的cardExt字符串 code、openid、outerStr可为空
+func (t *Trader) AddCardExt(cardId, code, openid, outerStr string) (ext string, err error) {
+	ticket, err := t.GetWxCardTicket()
+	if err != nil {
+		return
+	}
+	c := CardExt{
+		Code:      code,
+		OpenId:    openid,
+		Timestamp: fmt.Sprint(time.Now().Unix()),
+		NonceStr:  GetRandStr(16),
+		OuterStr:  outerStr,
+	}
+	c.Signature = CardSign(ticket, c.Timestamp, cardId, code, openid, c.NonceStr)
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// wx.config所需参数
+type JSSDKConfig struct {
+	AppId     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+// 返回wx.config参数的http.Handler
+/*
+	请求: GET /jssdk?url=<当前页面地址>
+	返回: {"appId":"...","timestamp":...,"nonceStr":"...","signature":"..."}
+	url的域名必须在AllowedHosts中，AllowedOrigins中的来源会收到CORS响应头
+*/
+type JSSDKHandler struct {
+	t *Trader
+	// 允许签名的域名，如 example.com 或 .example.com(包含全部子域名)
+	AllowedHosts []string
+	// 允许跨域请求的Origin，"*"表示全部
+	AllowedOrigins []string
+}
+
+func (t *Trader) NewJSSDKHandler(allowedHosts ...string) *JSSDKHandler {
+	return &JSSDKHandler{t: t, AllowedHosts: allowedHosts}
+}
+
+func (h *JSSDKHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.cors(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	pageURL, err := h.normalize(r.FormValue("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wf, err := h.t.WebConfig(pageURL)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(JSSDKConfig{
+		AppId:     wf.AppId,
+		Timestamp: wf.Timestamp,
+		NonceStr:  wf.Noncestr,
+		Signature: wf.Signature,
+	})
+}
+
+// 校验并规范化页面地址 去掉#及其后面部分
+func (h *JSSDKHandler) normalize(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing url")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+	if !h.allowHost(u.Hostname()) {
+		return "", fmt.Errorf("url host not allowed")
+	}
+	// 签名需与页面的location.href一致，因此只截掉#部分而不重新编码
+	if i := strings.Index(raw, "#"); i >= 0 {
+		raw = raw[:i]
+	}
+	return raw, nil
+}
+
+func (h *JSSDKHandler) allowHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, a := range h.AllowedHosts {
+		a = strings.ToLower(a)
+		if host == a || (strings.HasPrefix(a, ".") && (strings.HasSuffix(host, a) || host == a[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *JSSDKHandler) cors(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, o := range h.AllowedOrigins {
+		if o == "*" || o == origin {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
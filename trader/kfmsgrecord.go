@@ -0,0 +1,215 @@
+package trader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+/*
+  客服聊天记录
+  每次查询的时间范围不能超过24小时，KfMsgRecordIterator会自动按24小时拆分并逐页拉取
+*/
+
+type KfOperCode int
+
+const (
+	KfOperCreateWait     KfOperCode = 1000 //创建未接入会话
+	KfOperAccept         KfOperCode = 1001 //接入会话
+	KfOperInitiate       KfOperCode = 1002 //主动发起会话
+	KfOperTransfer       KfOperCode = 1003 //转接会话
+	KfOperClose          KfOperCode = 1004 //关闭会话
+	KfOperGrab           KfOperCode = 1005 //抢接会话
+	KfOperUserMsg        KfOperCode = 2001 //公众号收到消息
+	KfOperKfSend         KfOperCode = 2002 //客服发送消息
+	KfOperKfReceive      KfOperCode = 2003 //客服收到消息
+	kfMsgRecordMaxNumber            = 10000
+)
+
+func (c KfOperCode) String() string {
+	switch c {
+	case KfOperCreateWait:
+		return "create_wait_session"
+	case KfOperAccept:
+		return "accept_session"
+	case KfOperInitiate:
+		return "initiate_session"
+	case KfOperTransfer:
+		return "transfer_session"
+	case KfOperClose:
+		return "close_session"
+	case KfOperGrab:
+		return "grab_session"
+	case KfOperUserMsg:
+		return "user_message"
+	case KfOperKfSend:
+		return "kf_send"
+	case KfOperKfReceive:
+		return "kf_receive"
+	}
+	return "unknown"
+}
+
+type KfMsgRecord struct {
+	OpenId   string     `json:"openid"`
+	OperCode KfOperCode `json:"opercode"`
+	Text     string     `json:"text"`
+	Time     int64      `json:"time"`
+	Worker   string     `json:"worker"`
+}
+
+func (r KfMsgRecord) CreateTime() time.Time {
+	return time.Unix(r.Time, 0)
+}
+
+// 获取聊天记录 starttime与endtime相差不能超过24小时，msgid从1开始，number最大10000
+// nextMsgId为下一页的msgid，count小于number时表示没有更多记录
+func (t *Trader) GetKfMsgList(starttime, endtime int64, msgid int64, number int) (list []KfMsgRecord, nextMsgId int64, count int, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/customservice/msgrecord/getmsglist?access_token=" + t.Accesstoken
+	var p struct {
+		StartTime int64 `json:"starttime"`
+		EndTime   int64 `json:"endtime"`
+		MsgId     int64 `json:"msgid"`
+		Number    int   `json:"number"`
+	}
+	p.StartTime, p.EndTime, p.MsgId, p.Number = starttime, endtime, msgid, number
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode    int           `json:"errcode"`
+		ErrMsg     string        `json:"errmsg"`
+		RecordList []KfMsgRecord `json:"recordlist"`
+		Number     int           `json:"number"`
+		MsgId      int64         `json:"msgid"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	list, nextMsgId, count = r.RecordList, r.MsgId, r.Number
+	return
+}
+
+// 逐页遍历时间范围内的聊天记录 超过24小时的范围自动拆分为多个时间窗依次拉取
+// 接口的起止时间都包含在内，相邻时间窗从上一个的结束时间+1秒开始，边界上的记录不会重复
+/*
+	it := t.KfMsgRecordIterator(start, end)
+	for it.Next() {
+		for _, r := range it.Page() { ... }
+	}
+	if err := it.Err(); err != nil { ... }
+*/
+type KfMsgRecordIterator struct {
+	t      *Trader
+	start  int64
+	end    int64
+	winEnd int64
+	msgid  int64
+	page   []KfMsgRecord
+	done   bool
+	err    error
+
+	// 每页数量，默认10000
+	PageSize int
+}
+
+func (t *Trader) KfMsgRecordIterator(start, end time.Time) *KfMsgRecordIterator {
+	return &KfMsgRecordIterator{
+		t:        t,
+		start:    start.Unix(),
+		end:      end.Unix(),
+		msgid:    1,
+		PageSize: kfMsgRecordMaxNumber,
+	}
+}
+
+func (it *KfMsgRecordIterator) window() {
+	it.winEnd = it.start + 24*3600
+	if it.winEnd > it.end {
+		it.winEnd = it.end
+	}
+}
+
+// 拉取下一页，没有更多数据或出错时返回false
+func (it *KfMsgRecordIterator) Next() bool {
+	if it.PageSize <= 0 || it.PageSize > kfMsgRecordMaxNumber {
+		it.PageSize = kfMsgRecordMaxNumber
+	}
+	for !it.done && it.err == nil {
+		if it.start > it.end {
+			it.done = true
+			break
+		}
+		if it.winEnd == 0 {
+			it.window()
+		}
+		list, next, count, err := it.t.GetKfMsgList(it.start, it.winEnd, it.msgid, it.PageSize)
+		if err != nil {
+			it.err = err
+			break
+		}
+		if count < it.PageSize || next == 0 {
+			//当前时间窗已取完，进入下一个时间窗
+			it.start, it.msgid = it.winEnd+1, 1
+			it.window()
+		} else {
+			it.msgid = next
+		}
+		if len(list) > 0 {
+			it.page = list
+			return true
+		}
+	}
+	it.page = nil
+	return false
+}
+
+// 当前页的聊天记录
+func (it *KfMsgRecordIterator) Page() []KfMsgRecord {
+	return it.page
+}
+
+func (it *KfMsgRecordIterator) Err() error {
+	return it.err
+}
+
+// 将时间范围内的聊天记录以JSON Lines格式写入w 每行一条记录并附带opercode的文字说明
+func (t *Trader) ExportKfMsgRecords(w io.Writer, start, end time.Time) (n int, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	it := t.KfMsgRecordIterator(start, end)
+	for it.Next() {
+		for _, r := range it.Page() {
+			err = enc.Encode(struct {
+				KfMsgRecord
+				Oper string `json:"oper"`
+			}{r, r.OperCode.String()})
+			if err != nil {
+				return
+			}
+			n++
+		}
+	}
+	err = it.Err()
+	if err != nil {
+		return
+	}
+	err = bw.Flush()
+	return
+}
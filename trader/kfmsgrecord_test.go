@@ -0,0 +1,64 @@
+package trader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKfMsgRecordIteratorWindows(t *testing.T) {
+	type window struct{ start, end int64 }
+	var windows []window
+	// 每秒一条记录，每个时间窗一页取完
+	tr := newStubTrader(t, func(w http.ResponseWriter, r *http.Request) {
+		var p struct {
+			StartTime int64 `json:"starttime"`
+			EndTime   int64 `json:"endtime"`
+		}
+		json.NewDecoder(r.Body).Decode(&p)
+		windows = append(windows, window{p.StartTime, p.EndTime})
+		var list []KfMsgRecord
+		for ts := p.StartTime; ts <= p.EndTime; ts++ {
+			list = append(list, KfMsgRecord{OpenId: "o", OperCode: KfOperUserMsg, Time: ts})
+		}
+		b, _ := json.Marshal(list)
+		fmt.Fprintf(w, `{"recordlist":%s,"number":%d,"msgid":0}`, b, len(list))
+	})
+
+	start := time.Unix(1700000000, 0)
+	end := start.Add(50 * time.Hour)
+	it := tr.KfMsgRecordIterator(start, end)
+	seen := make(map[int64]int)
+	for it.Next() {
+		for _, r := range it.Page() {
+			seen[r.Time]++
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []window{
+		{start.Unix(), start.Unix() + 24*3600},
+		{start.Unix() + 24*3600 + 1, start.Unix() + 48*3600 + 1},
+		{start.Unix() + 48*3600 + 2, end.Unix()},
+	}
+	if fmt.Sprint(windows) != fmt.Sprint(want) {
+		t.Errorf("windows = %v, want %v", windows, want)
+	}
+	for _, w := range windows {
+		if w.end-w.start > 24*3600 {
+			t.Errorf("window %v longer than 24 hours", w)
+		}
+	}
+	if n := int(end.Unix() - start.Unix() + 1); len(seen) != n {
+		t.Errorf("%d distinct seconds, want %d", len(seen), n)
+	}
+	for ts, n := range seen {
+		if n != 1 {
+			t.Errorf("record at %d returned %d times", ts, n)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+/*
+  客服会话管理
+*/
+
+type (
+	KfSession struct {
+		KfAccount  string `json:"kf_account"`
+		OpenId     string `json:"openid"`
+		CreateTime int64  `json:"createtime"`
+	}
+	KfWaitCase struct {
+		OpenId     string `json:"openid"`
+		LatestTime int64  `json:"latest_time"`
+	}
+	KfOnline struct {
+		KfAccount    string `json:"kf_account"`
+		Status       int    `json:"status"` //1-web在线
+		KfId         string `json:"kf_id"`
+		AcceptedCase int    `json:"accepted_case"`
+	}
+)
+
+func (t *Trader) kfSession(action, kfaccount, openid string) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := KfSessionURL + action + "?access_token=" + t.Accesstoken
+	var p struct {
+		KfAccount string `json:"kf_account"`
+		OpenId    string `json:"openid"`
+	}
+	p.KfAccount, p.OpenId = kfaccount, openid
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
+
+// 创建会话 将用户接入指定客服
+func (t *Trader) CreateKfSession(kfaccount, openid string) error {
+	return t.kfSession("create", kfaccount, openid)
+}
+
+// 关闭会话
+func (t *Trader) CloseKfSession(kfaccount, openid string) error {
+	return t.kfSession("close", kfaccount, openid)
+}
+
+func (t *Trader) getKf(surl string, v interface{}) (err error) {
+	b, err := t.Get(surl)
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	err = json.Unmarshal(b, v)
+	return
+}
+
+// 获取用户的会话状态 kf_account为空表示未接入
+func (t *Trader) GetKfSession(openid string) (s KfSession, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := KfSessionURL + "getsession?access_token=" + t.Accesstoken + "&openid=" + url.QueryEscape(openid)
+	err = t.getKf(surl, &s)
+	s.OpenId = openid
+	return
+}
+
+// 获取客服的会话列表
+func (t *Trader) GetKfSessionList(kfaccount string) (list []KfSession, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := KfSessionURL + "getsessionlist?access_token=" + t.Accesstoken + "&kf_account=" + url.QueryEscape(kfaccount)
+	var r struct {
+		SessionList []KfSession `json:"sessionlist"`
+	}
+	err = t.getKf(surl, &r)
+	for _, s := range r.SessionList {
+		s.KfAccount = kfaccount
+		list = append(list, s)
+	}
+	return
+}
+
+// 获取未接入会话列表 最多返回100个
+func (t *Trader) GetKfWaitCase() (count int, list []KfWaitCase, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := KfSessionURL + "getwaitcase?access_token=" + t.Accesstoken
+	var r struct {
+		Count        int          `json:"count"`
+		WaitCaseList []KfWaitCase `json:"waitcaselist"`
+	}
+	err = t.getKf(surl, &r)
+	count, list = r.Count, r.WaitCaseList
+	return
+}
+
+// 获取在线客服列表
+func (t *Trader) GetOnlineKfList() (list []KfOnline, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/customservice/getonlinekflist?access_token=" + t.Accesstoken
+	var r struct {
+		KfOnlineList []KfOnline `json:"kf_online_list"`
+	}
+	err = t.getKf(surl, &r)
+	list = r.KfOnlineList
+	return
+}
+
+// 邀请微信用户绑定客服账号 inviteWx为客服的微信号
+func (t *Trader) InviteKfWorker(kfaccount, inviteWx string) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := KFaccountURL + "inviteworker?access_token=" + t.Accesstoken
+	var p struct {
+		KfAccount string `json:"kf_account"`
+		InviteWx  string `json:"invite_wx"`
+	}
+	p.KfAccount, p.InviteWx = kfaccount, inviteWx
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package trader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/slrem/wechat/metrics"
+)
+
+/*
+  接口调用指标
+  设置Trader.Metrics后，Get、PostJson和PostMultipart的每次调用按接口路径和errcode计数并记录耗时，
+  access_token的刷新按结果计数
+*/
+
+// 接口路径 不含access_token等参数
+func apiEndpoint(surl string) string {
+	u, err := url.Parse(surl)
+	if err != nil || u.Path == "" {
+		return "unknown"
+	}
+	return u.Path
+}
+
+// 响应中的errcode 非JSON响应(如图片)视为0，请求失败为transport
+func apiErrCode(b []byte, err error) string {
+	if err != nil {
+		return "transport"
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return "0"
+	}
+	var r Res
+	if json.Unmarshal(b, &r) != nil {
+		return "invalid"
+	}
+	return strconv.Itoa(r.ErrCode)
+}
+
+func (t *Trader) observe(surl string, start time.Time, b []byte, err error) {
+	if t == nil || t.Metrics == nil {
+		return
+	}
+	endpoint := apiEndpoint(surl)
+	t.Metrics.Inc(metrics.APIRequestsTotal, "endpoint", endpoint, "errcode", apiErrCode(b, err))
+	t.Metrics.Observe(metrics.APIDuration, time.Since(start).Seconds(), "endpoint", endpoint)
+}
+
+func (t *Trader) tokenRefreshed(err error) {
+	if t == nil || t.Metrics == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	t.Metrics.Inc(metrics.TokenRefreshTotal, "result", result)
+}
+
+type ApiQuota struct {
+	DailyLimit int64 `json:"daily_limit"`
+	Used       int64 `json:"used"`
+	Remain     int64 `json:"remain"`
+}
+
+// 查询接口的每日调用额度 cgiPath如 /cgi-bin/message/custom/send
+func (t *Trader) GetApiQuota(cgiPath string) (q ApiQuota, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/openapi/quota/get?access_token=" + t.Accesstoken
+	var p struct {
+		CgiPath string `json:"cgi_path"`
+	}
+	p.CgiPath = cgiPath
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode int      `json:"errcode"`
+		ErrMsg  string   `json:"errmsg"`
+		Quota   ApiQuota `json:"quota"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	q = r.Quota
+	return
+}
+
+// 查询各接口的额度并写入Metrics 遇到错误时继续查询其余接口，返回第一个错误
+func (t *Trader) ReportApiQuota(cgiPaths ...string) (err error) {
+	for _, p := range cgiPaths {
+		q, e := t.GetApiQuota(p)
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		if t.Metrics != nil {
+			t.Metrics.Set(metrics.APIQuotaRemaining, float64(q.Remain), "endpoint", p)
+			t.Metrics.Set(metrics.APIQuotaDailyLimit, float64(q.DailyLimit), "endpoint", p)
+		}
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+/*
+  网页授权
+  1. 用AuthorizeURL引导用户跳转授权，回调地址会带上code和state
+  2. 用ExchangeCode换取网页授权access_token和openid
+  3. scope为snsapi_userinfo时可用GetSnsUserInfo获取用户信息
+*/
+
+const (
+	SnsapiBase     = "snsapi_base"
+	SnsapiUserinfo = "snsapi_userinfo"
+)
+
+type OAuthToken struct {
+	AccessToken    string `json:"access_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+	RefreshToken   string `json:"refresh_token"`
+	OpenId         string `json:"openid"`
+	Scope          string `json:"scope"`
+	IsSnapshotUser int    `json:"is_snapshotuser"`
+	UnionId        string `json:"unionid"`
+}
+
+type SnsUserInfo struct {
+	OpenId     string   `json:"openid"`
+	NickName   string   `json:"nickname"`
+	Sex        int      `json:"sex"`
+	Province   string   `json:"province"`
+	City       string   `json:"city"`
+	Country    string   `json:"country"`
+	HeadimgUrl string   `json:"headimgurl"`
+	Privilege  []string `json:"privilege"`
+	UnionId    string   `json:"unionid"`
+}
+
+// 网页授权链接 state最长128字节，建议用SignState生成
+func (t *Trader) AuthorizeURL(redirectUri, scope, state string) string {
+	q := url.Values{}
+	q.Set("appid", t.AppId)
+	q.Set("redirect_uri", redirectUri)
+	q.Set("response_type", "code")
+	q.Set("scope", scope)
+	q.Set("state", state)
+	return OAuthAuthorizeURL + "?" + q.Encode() + "#wechat_redirect"
+}
+
+func (t *Trader) getOAuth(surl string, v interface{}) (err error) {
+	b, err := t.Get(surl)
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	if v != nil {
+		err = json.Unmarshal(b, v)
+	}
+	return
+}
+
+// 通过code换取网页授权access_token
+func (t *Trader) ExchangeCode(code string) (token OAuthToken, err error) {
+	q := url.Values{}
+	q.Set("appid", t.AppId)
+	q.Set("secret", t.AppSecret)
+	q.Set("code", code)
+	q.Set("grant_type", "authorization_code")
+	err = t.getOAuth(SnsURL+"oauth2/access_token?"+q.Encode(), &token)
+	return
+}
+
+// 刷新网页授权access_token
+func (t *Trader) RefreshOAuthToken(refreshToken string) (token OAuthToken, err error) {
+	q := url.Values{}
+	q.Set("appid", t.AppId)
+	q.Set("grant_type", "refresh_token")
+	q.Set("refresh_token", refreshToken)
+	err = t.getOAuth(SnsURL+"oauth2/refresh_token?"+q.Encode(), &token)
+	return
+}
+
+// 检验网页授权access_token是否有效
+func (t *Trader) CheckOAuthToken(accessToken, openid string) (err error) {
+	q := url.Values{}
+	q.Set("access_token", accessToken)
+	q.Set("openid", openid)
+	return t.getOAuth(SnsURL+"auth?"+q.Encode(), nil)
+}
+
+// 拉取用户信息 需scope为snsapi_userinfo
+func (t *Trader) GetSnsUserInfo(accessToken, openid string) (info SnsUserInfo, err error) {
+	q := url.Values{}
+	q.Set("access_token", accessToken)
+	q.Set("openid", openid)
+	q.Set("lang", "zh_CN")
+	err = t.getOAuth(SnsURL+"userinfo?"+q.Encode(), &info)
+	return
+}
@@ -0,0 +1,316 @@
+package trader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidStateError   = errors.New("invalid oauth state")
+	ExpiredStateError   = errors.New("oauth state expired")
+	InvalidSessionError = errors.New("invalid oauth session")
+	AccessDeniedError   = errors.New("oauth access denied")
+)
+
+// 签名用途 同一个key签出的state和登录cookie不能互相冒用
+const (
+	stateDomain   = "state"
+	sessionDomain = "session"
+)
+
+func hmacSign(key []byte, domain, data string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(domain + "\x00"))
+	m.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// 生成带签名的state 格式为 时间戳.随机串.签名，长度不超过128字节
+func SignState(key []byte) string {
+	return SignBoundState(key, "")
+}
+
+// 校验SignState生成的state maxAge<=0时不检查有效期
+func VerifyState(key []byte, state string, maxAge time.Duration) error {
+	return VerifyBoundState(key, state, "", maxAge)
+}
+
+// 生成与binding绑定的state binding参与签名但不出现在state中，
+// 通常为写入浏览器cookie的随机数，防止把他人发起的授权回调交给受害者完成
+func SignBoundState(key []byte, binding string) string {
+	data := strconv.FormatInt(time.Now().Unix(), 36) + "." + GetRandStr(16)
+	return data + "." + hmacSign(key, stateDomain, data+"."+binding)[:22]
+}
+
+// 校验SignBoundState生成的state binding需与生成时一致
+func VerifyBoundState(key []byte, state, binding string, maxAge time.Duration) error {
+	i := strings.LastIndex(state, ".")
+	if i < 0 {
+		return InvalidStateError
+	}
+	data, sig := state[:i], state[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(hmacSign(key, stateDomain, data+"."+binding)[:22])) {
+		return InvalidStateError
+	}
+	ts, err := strconv.ParseInt(strings.SplitN(data, ".", 2)[0], 36, 64)
+	if err != nil {
+		return InvalidStateError
+	}
+	if maxAge > 0 && time.Since(time.Unix(ts, 0)) > maxAge {
+		return ExpiredStateError
+	}
+	return nil
+}
+
+// 网页授权得到的用户身份
+type OAuthUser struct {
+	OpenId  string `json:"openid"`
+	UnionId string `json:"unionid,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+type oauthUserKey struct{}
+
+// 获取OAuthMiddleware放入请求上下文的用户
+func OAuthUserFromContext(ctx context.Context) (u OAuthUser, ok bool) {
+	u, ok = ctx.Value(oauthUserKey{}).(OAuthUser)
+	return
+}
+
+// 网页授权中间件
+// 未登录的访问跳转到微信授权，授权回来后换取openid写入签名cookie，并跳转回去掉code和state的原地址
+// 只有state能用本次授权写入的nonce cookie验证通过时才当作授权回调，页面自己的state参数不受影响
+// 已登录的访问将OAuthUser放入请求上下文，用OAuthUserFromContext获取
+type OAuthMiddleware struct {
+	t   *Trader
+	key []byte
+
+	Scope string
+	// 登录状态cookie
+	CookieName   string
+	CookieMaxAge time.Duration
+	Secure       bool
+	// 授权跳转的有效期
+	StateMaxAge time.Duration
+	// 站点外部地址，如 https://example.com ，为空时从请求推断
+	BaseURL string
+	// BaseURL为空时信任X-Forwarded-Proto和X-Forwarded-Host，仅在可信反向代理之后开启
+	TrustProxyHeaders bool
+	// 换取token成功后调用，scope为snsapi_userinfo时info为拉取到的用户信息
+	OnLogin func(r *http.Request, token OAuthToken, info *SnsUserInfo) error
+	// 授权失败时调用，默认返回403；用户拒绝授权时err为AccessDeniedError
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// key用于签名state和cookie，需保密
+func (t *Trader) NewOAuthMiddleware(key []byte, scope string) *OAuthMiddleware {
+	return &OAuthMiddleware{
+		t:            t,
+		key:          key,
+		Scope:        scope,
+		CookieName:   "wx_oauth",
+		CookieMaxAge: 7 * 24 * time.Hour,
+		StateMaxAge:  10 * time.Minute,
+	}
+}
+
+func (m *OAuthMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, err := m.session(r); err == nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oauthUserKey{}, u)))
+			return
+		}
+
+		ok, err := m.isCallback(r)
+		if !ok {
+			m.authorize(w, r)
+			return
+		}
+		m.clearNonce(w)
+		if err != nil {
+			m.fail(w, r, err)
+			return
+		}
+		// 用户拒绝授权时微信只带回state
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			m.fail(w, r, AccessDeniedError)
+			return
+		}
+
+		u, err := m.login(r, code)
+		if err != nil {
+			m.fail(w, r, err)
+			return
+		}
+		m.setSession(w, u)
+		http.Redirect(w, r, m.requestURL(r), http.StatusFound)
+	})
+}
+
+// 跳转到微信授权 state与写入cookie的随机数绑定，回调时校验
+func (m *OAuthMiddleware) authorize(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		m.fail(w, r, err)
+		return
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.nonceCookie(),
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(m.StateMaxAge / time.Second),
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, m.t.AuthorizeURL(m.requestURL(r), m.Scope, SignBoundState(m.key, nonce)), http.StatusFound)
+}
+
+// 请求是否为authorize发起的授权回调 state签名正确但已过期时ok为true并返回ExpiredStateError
+func (m *OAuthMiddleware) isCallback(r *http.Request) (ok bool, err error) {
+	state := r.URL.Query().Get("state")
+	c, e := r.Cookie(m.nonceCookie())
+	if state == "" || e != nil || c.Value == "" {
+		return
+	}
+	err = VerifyBoundState(m.key, state, c.Value, m.StateMaxAge)
+	if err == InvalidStateError {
+		return false, nil
+	}
+	return true, err
+}
+
+func (m *OAuthMiddleware) nonceCookie() string {
+	return m.CookieName + "_nonce"
+}
+
+func (m *OAuthMiddleware) clearNonce(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.nonceCookie(),
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (m *OAuthMiddleware) login(r *http.Request, code string) (u OAuthUser, err error) {
+	token, err := m.t.ExchangeCode(code)
+	if err != nil {
+		return
+	}
+	var info *SnsUserInfo
+	if token.Scope == SnsapiUserinfo && token.IsSnapshotUser == 0 {
+		var i SnsUserInfo
+		i, err = m.t.GetSnsUserInfo(token.AccessToken, token.OpenId)
+		if err != nil {
+			return
+		}
+		info = &i
+		if token.UnionId == "" {
+			token.UnionId = i.UnionId
+		}
+	}
+	if m.OnLogin != nil {
+		err = m.OnLogin(r, token, info)
+		if err != nil {
+			return
+		}
+	}
+	u = OAuthUser{
+		OpenId:  token.OpenId,
+		UnionId: token.UnionId,
+		Expires: time.Now().Add(m.CookieMaxAge).Unix(),
+	}
+	return
+}
+
+func (m *OAuthMiddleware) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if m.OnError != nil {
+		m.OnError(w, r, err)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func (m *OAuthMiddleware) session(r *http.Request) (u OAuthUser, err error) {
+	c, err := r.Cookie(m.CookieName)
+	if err != nil {
+		return
+	}
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		err = InvalidSessionError
+		return
+	}
+	data, sig := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(hmacSign(m.key, sessionDomain, data))) {
+		err = InvalidSessionError
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &u)
+	if err != nil {
+		return
+	}
+	if u.OpenId == "" || time.Now().Unix() > u.Expires {
+		err = InvalidSessionError
+	}
+	return
+}
+
+func (m *OAuthMiddleware) setSession(w http.ResponseWriter, u OAuthUser) {
+	b, _ := json.Marshal(u)
+	data := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.CookieName,
+		Value:    data + "." + hmacSign(m.key, sessionDomain, data),
+		Path:     "/",
+		MaxAge:   int(m.CookieMaxAge / time.Second),
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// 当前请求去掉code和state参数后的完整地址
+func (m *OAuthMiddleware) requestURL(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("code")
+	q.Del("state")
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	base := strings.TrimRight(m.BaseURL, "/")
+	if base == "" {
+		scheme, host := "http", r.Host
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if m.TrustProxyHeaders {
+			if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+				scheme = p
+			}
+			if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+				host = strings.TrimSpace(strings.Split(h, ",")[0])
+			}
+		}
+		base = fmt.Sprintf("%s://%s", scheme, host)
+	}
+	return base + u.String()
+}
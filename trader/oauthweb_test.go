@@ -0,0 +1,189 @@
+package trader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("secret")
+
+// 用指定时间生成state
+func stateAt(key []byte, binding string, at time.Time) string {
+	data := strconv.FormatInt(at.Unix(), 36) + ".abcdefghijklmnop"
+	return data + "." + hmacSign(key, stateDomain, data+"."+binding)[:22]
+}
+
+func TestVerifyBoundState(t *testing.T) {
+	state := SignBoundState(testKey, "nonce")
+	i := strings.LastIndex(state, ".")
+	tests := []struct {
+		name    string
+		key     []byte
+		state   string
+		binding string
+		maxAge  time.Duration
+		want    error
+	}{
+		{"valid", testKey, state, "nonce", time.Minute, nil},
+		{"wrong binding", testKey, state, "other", time.Minute, InvalidStateError},
+		{"missing binding", testKey, state, "", time.Minute, InvalidStateError},
+		{"wrong key", []byte("other"), state, "nonce", time.Minute, InvalidStateError},
+		{"tampered data", testKey, "0" + state, "nonce", time.Minute, InvalidStateError},
+		{"tampered signature", testKey, state[:i+1] + strings.Repeat("A", 22), "nonce", time.Minute, InvalidStateError},
+		{"no signature", testKey, state[:i], "nonce", time.Minute, InvalidStateError},
+		{"garbage", testKey, "state", "nonce", time.Minute, InvalidStateError},
+		{"expired", testKey, stateAt(testKey, "nonce", time.Now().Add(-time.Hour)), "nonce", time.Minute, ExpiredStateError},
+		{"no max age", testKey, stateAt(testKey, "nonce", time.Now().Add(-time.Hour)), "nonce", 0, nil},
+		{"session signature", testKey, "data." + hmacSign(testKey, sessionDomain, "data.nonce")[:22], "nonce", 0, InvalidStateError},
+	}
+	for _, tt := range tests {
+		if err := VerifyBoundState(tt.key, tt.state, tt.binding, tt.maxAge); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if len(state) > 128 {
+		t.Errorf("state is %d bytes", len(state))
+	}
+	if err := VerifyState(testKey, SignState(testKey), time.Minute); err != nil {
+		t.Errorf("VerifyState: %v", err)
+	}
+}
+
+func TestOAuthSession(t *testing.T) {
+	m := (&Trader{}).NewOAuthMiddleware(testKey, SnsapiBase)
+	cookie := func(u OAuthUser) *http.Cookie {
+		rec := httptest.NewRecorder()
+		m.setSession(rec, u)
+		return rec.Result().Cookies()[0]
+	}
+	valid := cookie(OAuthUser{OpenId: "o1", Expires: time.Now().Add(time.Hour).Unix()})
+	data := valid.Value[:strings.LastIndex(valid.Value, ".")]
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"valid", valid.Value, true},
+		{"expired", cookie(OAuthUser{OpenId: "o1", Expires: time.Now().Add(-time.Second).Unix()}).Value, false},
+		{"no openid", cookie(OAuthUser{Expires: time.Now().Add(time.Hour).Unix()}).Value, false},
+		{"tampered", "x" + valid.Value, false},
+		{"unsigned", data, false},
+		{"state signature", data + "." + hmacSign(testKey, stateDomain, data), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: m.CookieName, Value: tt.value})
+		u, err := m.session(r)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		if tt.ok && u.OpenId != "o1" {
+			t.Errorf("%s: got %+v", tt.name, u)
+		}
+	}
+}
+
+func TestOAuthMiddleware(t *testing.T) {
+	tr := newStubTrader(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("code") != "good" {
+			fmt.Fprint(w, `{"errcode":40029,"errmsg":"invalid code"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"at","expires_in":7200,"openid":"o1","scope":"snsapi_base"}`)
+	})
+	m := tr.NewOAuthMiddleware(testKey, SnsapiBase)
+	m.BaseURL = "https://example.com"
+	var failed error
+	m.OnError = func(w http.ResponseWriter, r *http.Request, err error) {
+		failed = err
+		w.WriteHeader(http.StatusForbidden)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := OAuthUserFromContext(r.Context())
+		fmt.Fprint(w, u.OpenId)
+	}))
+	serve := func(target string, cookies ...*http.Cookie) *http.Response {
+		r := httptest.NewRequest("GET", target, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Result()
+	}
+	cookieNamed := func(res *http.Response, name string) *http.Cookie {
+		for _, c := range res.Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// 未登录跳转授权，页面自己的state参数不当作回调
+	res := serve("/page?state=mine&code=x")
+	if res.StatusCode != http.StatusFound || !strings.HasPrefix(res.Header.Get("Location"), OAuthAuthorizeURL) {
+		t.Fatalf("page state: %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	nonce := cookieNamed(res, m.nonceCookie())
+	loc, _ := url.Parse(res.Header.Get("Location"))
+	state := loc.Query().Get("state")
+	if nonce == nil || VerifyBoundState(testKey, state, nonce.Value, time.Minute) != nil {
+		t.Fatalf("state %q not bound to nonce cookie %v", state, nonce)
+	}
+	if got := loc.Query().Get("redirect_uri"); got != "https://example.com/page" {
+		t.Errorf("redirect_uri = %s", got)
+	}
+
+	// 页面自己的state 即使存在nonce cookie也不当作回调
+	res = serve("/page?state=mine", nonce)
+	if res.StatusCode != http.StatusFound || failed != nil {
+		t.Errorf("foreign state with nonce: %d %v", res.StatusCode, failed)
+	}
+
+	// 用户拒绝授权
+	res = serve("/page?state="+url.QueryEscape(state), nonce)
+	if res.StatusCode != http.StatusForbidden || failed != AccessDeniedError {
+		t.Errorf("denied: %d %v", res.StatusCode, failed)
+	}
+	if c := cookieNamed(res, m.nonceCookie()); c == nil || c.MaxAge >= 0 {
+		t.Errorf("nonce cookie not cleared on denial: %v", c)
+	}
+
+	// 过期的回调
+	failed = nil
+	old := stateAt(testKey, nonce.Value, time.Now().Add(-time.Hour))
+	if res = serve("/page?code=good&state="+url.QueryEscape(old), nonce); failed != ExpiredStateError {
+		t.Errorf("expired: %d %v", res.StatusCode, failed)
+	}
+
+	// 换取失败
+	failed = nil
+	if res = serve("/page?code=bad&state="+url.QueryEscape(state), nonce); ErrCode(failed) != 40029 {
+		t.Errorf("bad code: %d %v", res.StatusCode, failed)
+	}
+
+	// 成功登录后跳回去掉code和state的地址，cookie可用
+	failed = nil
+	res = serve("/page?a=1&code=good&state="+url.QueryEscape(state), nonce)
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "https://example.com/page?a=1" || failed != nil {
+		t.Fatalf("login: %d %s %v", res.StatusCode, res.Header.Get("Location"), failed)
+	}
+	session := cookieNamed(res, m.CookieName)
+	if session == nil {
+		t.Fatal("no session cookie")
+	}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/page?a=1", nil)
+	r.AddCookie(session)
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "o1" {
+		t.Errorf("with session: %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,118 @@
+package trader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"net/url"
+)
+
+/*
+  带参数二维码
+  临时二维码最长30天，永久二维码数量上限为10万个
+*/
+
+const (
+	qrScene         = "QR_SCENE"
+	qrStrScene      = "QR_STR_SCENE"
+	qrLimitScene    = "QR_LIMIT_SCENE"
+	qrLimitStrScene = "QR_LIMIT_STR_SCENE"
+)
+
+type Qrcode struct {
+	Ticket        string `json:"ticket"`
+	ExpireSeconds int    `json:"expire_seconds"`
+	Url           string `json:"url"`
+}
+
+func (t *Trader) createQrcode(actionName string, expireSeconds, sceneId int, sceneStr string) (q Qrcode, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/qrcode/create?access_token=" + t.Accesstoken
+	var p struct {
+		ExpireSeconds int    `json:"expire_seconds,omitempty"`
+		ActionName    string `json:"action_name"`
+		ActionInfo    struct {
+			Scene struct {
+				SceneId  int    `json:"scene_id,omitempty"`
+				SceneStr string `json:"scene_str,omitempty"`
+			} `json:"scene"`
+		} `json:"action_info"`
+	}
+	p.ExpireSeconds, p.ActionName = expireSeconds, actionName
+	p.ActionInfo.Scene.SceneId, p.ActionInfo.Scene.SceneStr = sceneId, sceneStr
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &q)
+	if err != nil {
+		return
+	}
+	if q.Ticket == "" {
+		err = errors.New(string(b))
+	}
+	return
+}
+
+// 创建临时整型参数二维码 expireSeconds最大2592000(30天)
+func (t *Trader) CreateTempQrcode(sceneId, expireSeconds int) (Qrcode, error) {
+	return t.createQrcode(qrScene, expireSeconds, sceneId, "")
+}
+
+// 创建临时字符串参数二维码 sceneStr长度1到64
+func (t *Trader) CreateTempStrQrcode(sceneStr string, expireSeconds int) (Qrcode, error) {
+	return t.createQrcode(qrStrScene, expireSeconds, 0, sceneStr)
+}
+
+// 创建永久整型参数二维码 sceneId取值1到100000
+func (t *Trader) CreateLimitQrcode(sceneId int) (Qrcode, error) {
+	return t.createQrcode(qrLimitScene, 0, sceneId, "")
+}
+
+// 创建永久字符串参数二维码 sceneStr长度1到64
+func (t *Trader) CreateLimitStrQrcode(sceneStr string) (Qrcode, error) {
+	return t.createQrcode(qrLimitStrScene, 0, 0, sceneStr)
+}
+
+// 二维码图片地址
+func QrcodeURL(ticket string) string {
+	return "https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=" + url.QueryEscape(ticket)
+}
+
+// 通过ticket下载二维码图片 返回微信原始图片数据(jpg)
+func (t *Trader) ShowQrcode(ticket string) (data []byte, err error) {
+	data, err = t.Get(QrcodeURL(ticket))
+	if err != nil {
+		return
+	}
+	if bytes.HasPrefix(data, []byte("{")) {
+		err = errors.New(string(data))
+	}
+	return
+}
+
+// 通过ticket下载二维码并转换为PNG
+func (t *Trader) QrcodePNG(ticket string) (data []byte, err error) {
+	raw, err := t.ShowQrcode(ticket)
+	if err != nil {
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	err = png.Encode(buf, img)
+	data = buf.Bytes()
+	return
+}
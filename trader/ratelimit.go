@@ -0,0 +1,59 @@
+package trader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流器 rate为每秒产生的令牌数 burst为桶容量
+type limiter struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait 阻塞直到取得一个令牌或ctx结束，rate<=0时不限流
+func (l *limiter) Wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0 {
+		return ctx.Err()
+	}
+	for {
+		l.mtx.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mtx.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mtx.Unlock()
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package trader
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+  短key托管
+  将长信息转换为短key，有效期最长30天
+*/
+
+// 短key的最长有效期(秒)
+const MaxShortKeyExpire = 30 * 24 * 3600
+
+type ShortKeyInfo struct {
+	LongData      string `json:"long_data"`
+	CreateTime    int64  `json:"create_time"`
+	ExpireSeconds int64  `json:"expire_seconds"`
+}
+
+// 过期时间
+func (s ShortKeyInfo) ExpireTime() time.Time {
+	return time.Unix(s.CreateTime+s.ExpireSeconds, 0)
+}
+
+// 生成短key expireSeconds<=0时使用最长有效期
+func (t *Trader) GenShortKey(longData string, expireSeconds int) (shortKey string, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	if expireSeconds <= 0 || expireSeconds > MaxShortKeyExpire {
+		expireSeconds = MaxShortKeyExpire
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/shorten/gen?access_token=" + t.Accesstoken
+	var p struct {
+		LongData      string `json:"long_data"`
+		ExpireSeconds int    `json:"expire_seconds"`
+	}
+	p.LongData, p.ExpireSeconds = longData, expireSeconds
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode  int    `json:"errcode"`
+		ErrMsg   string `json:"errmsg"`
+		ShortKey string `json:"short_key"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 || r.ShortKey == "" {
+		err = errors.New(string(b))
+		return
+	}
+	shortKey = r.ShortKey
+	return
+}
+
+// 还原短key
+func (t *Trader) FetchShortKey(shortKey string) (info ShortKeyInfo, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/shorten/fetch?access_token=" + t.Accesstoken
+	var p struct {
+		ShortKey string `json:"short_key"`
+	}
+	p.ShortKey = shortKey
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	err = json.Unmarshal(b, &info)
+	return
+}
+
+type shortKeyEntry struct {
+	longData string
+	shortKey string
+	renewAt  time.Time
+}
+
+// 带缓存的短key生成 相同的长信息在短key过期前复用已有的短key
+type ShortKeyCache struct {
+	t   *Trader
+	mtx sync.Mutex
+	ll  *list.List
+	m   map[string]*list.Element
+
+	// 最多缓存的条数，超出时淘汰最久未使用的，默认1000
+	Size int
+	// 新生成短key的有效期(秒)，默认30天
+	ExpireSeconds int
+	// 剩余有效期不足该值时重新生成，默认1天；不小于有效期时按有效期的一半处理
+	MinRemaining time.Duration
+}
+
+func (t *Trader) NewShortKeyCache() *ShortKeyCache {
+	return &ShortKeyCache{
+		t:             t,
+		ll:            list.New(),
+		m:             make(map[string]*list.Element),
+		Size:          1000,
+		ExpireSeconds: MaxShortKeyExpire,
+		MinRemaining:  24 * time.Hour,
+	}
+}
+
+func (c *ShortKeyCache) Get(longData string) (shortKey string, err error) {
+	c.mtx.Lock()
+	if el, ok := c.m[longData]; ok {
+		e := el.Value.(*shortKeyEntry)
+		if time.Now().Before(e.renewAt) {
+			c.ll.MoveToFront(el)
+			c.mtx.Unlock()
+			return e.shortKey, nil
+		}
+		c.ll.Remove(el)
+		delete(c.m, longData)
+	}
+	c.mtx.Unlock()
+
+	shortKey, err = c.t.GenShortKey(longData, c.ExpireSeconds)
+	if err != nil {
+		return
+	}
+	expire := c.ExpireSeconds
+	if expire <= 0 || expire > MaxShortKeyExpire {
+		expire = MaxShortKeyExpire
+	}
+	ttl := time.Duration(expire) * time.Second
+	remaining := c.MinRemaining
+	if remaining >= ttl {
+		remaining = ttl / 2
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if el, ok := c.m[longData]; ok {
+		c.ll.Remove(el)
+	}
+	c.m[longData] = c.ll.PushFront(&shortKeyEntry{
+		longData: longData,
+		shortKey: shortKey,
+		renewAt:  time.Now().Add(ttl - remaining),
+	})
+	for c.Size > 0 && c.ll.Len() > c.Size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.m, el.Value.(*shortKeyEntry).longData)
+	}
+	return
+}
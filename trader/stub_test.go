@@ -0,0 +1,35 @@
+package trader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// 将发往api.weixin.qq.com的请求转到本地测试服务器
+type stubTransport struct {
+	base http.RoundTripper
+	host string
+}
+
+func (s stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = "http", s.host
+	return s.base.RoundTrip(r)
+}
+
+// 返回使用stub接口的Trader，测试结束后恢复http.DefaultTransport
+func newStubTrader(t *testing.T, h http.HandlerFunc) *Trader {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	u, _ := url.Parse(srv.URL)
+	orig := http.DefaultTransport
+	http.DefaultTransport = stubTransport{base: orig, host: u.Host}
+	t.Cleanup(func() {
+		http.DefaultTransport = orig
+		srv.Close()
+	})
+	return &Trader{Accesstoken: "token", ExpiresIn: time.Now().Unix() + 7200}
+}
@@ -0,0 +1,219 @@
+package trader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+/*
+  订阅消息
+  长期订阅通过模板库(wxaapi/newtmpl)选用模板后用subscribe/bizsend发送
+  一次性订阅需用户先通过SubscribeConfirmURL授权，再用template/subscribe发送
+*/
+
+// 从公共模板库选用模板到私有模板库 返回添加至账号下的模板id
+func (t *Trader) AddSubscribeTemplate(tid string, kidList []int, sceneDesc string) (priTmplId string, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := NewTmplURL + "addtemplate?access_token=" + t.Accesstoken
+	var p struct {
+		Tid       string `json:"tid"`
+		KidList   []int  `json:"kidList"`
+		SceneDesc string `json:"sceneDesc"`
+	}
+	p.Tid, p.KidList, p.SceneDesc = tid, kidList, sceneDesc
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode   int    `json:"errcode"`
+		ErrMsg    string `json:"errmsg"`
+		PriTmplId string `json:"priTmplId"`
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	} else {
+		priTmplId = r.PriTmplId
+	}
+	return
+}
+
+// 删除私有模板库中的模板
+func (t *Trader) DelSubscribeTemplate(priTmplId string) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := NewTmplURL + "deltemplate?access_token=" + t.Accesstoken
+	var p struct {
+		PriTmplId string `json:"priTmplId"`
+	}
+	p.PriTmplId = priTmplId
+	str, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
+
+// newtmpl系列GET接口的公共部分 data为返回中data字段的解析目标
+func (t *Trader) getNewTmpl(action string, query url.Values, data interface{}) (count int, err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_token", t.Accesstoken)
+	b, err := t.Get(NewTmplURL + action + "?" + query.Encode())
+	if err != nil {
+		return
+	}
+	var r struct {
+		ErrCode int         `json:"errcode"`
+		ErrMsg  string      `json:"errmsg"`
+		Count   int         `json:"count"`
+		Data    interface{} `json:"data"`
+	}
+	r.Data = data
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+		return
+	}
+	count = r.Count
+	return
+}
+
+// 获取公众号所属类目
+func (t *Trader) GetSubscribeCategory() (list []SubscribeCategory, err error) {
+	_, err = t.getNewTmpl("getcategory", nil, &list)
+	return
+}
+
+// 获取类目下的公共模板 ids为类目id start从0开始 limit最大30
+func (t *Trader) GetPubTemplateTitles(ids []int, start, limit int) (list []PubTemplateTitle, count int, err error) {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	q := url.Values{}
+	q.Set("ids", strings.Join(s, ","))
+	q.Set("start", fmt.Sprint(start))
+	q.Set("limit", fmt.Sprint(limit))
+	count, err = t.getNewTmpl("getpubtemplatetitles", q, &list)
+	return
+}
+
+// 获取公共模板的关键词列表
+func (t *Trader) GetPubTemplateKeywords(tid string) (list []PubTemplateKeyword, err error) {
+	q := url.Values{}
+	q.Set("tid", tid)
+	_, err = t.getNewTmpl("getpubtemplatekeywords", q, &list)
+	return
+}
+
+// 获取私有模板列表
+func (t *Trader) GetSubscribeTemplates() (list []SubscribeTemplate, err error) {
+	_, err = t.getNewTmpl("gettemplate", nil, &list)
+	return
+}
+
+// 发送长期订阅消息
+func (t *Trader) SendSubscribeMsg(msg SubscribeMsg) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := SubscribeMsgURL + "bizsend?access_token=" + t.Accesstoken
+	str, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
+
+// 发送一次性订阅消息 scene需与授权时的scene一致
+func (t *Trader) SendOnceSubscribeMsg(msg OnceSubscribeMsg) (err error) {
+	err = t.CheckAccessTokenLive()
+	if err != nil {
+		return
+	}
+	surl := "https://api.weixin.qq.com/cgi-bin/message/template/subscribe?access_token=" + t.Accesstoken
+	str, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	b, err := t.PostJson(surl, string(str))
+	if err != nil {
+		return
+	}
+	var r Res
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return
+	}
+	if r.ErrCode != 0 {
+		err = errors.New(string(b))
+	}
+	return
+}
+
+//一次性订阅消息授权链接
+/*
+scene 订阅场景值 0-10000的整数
+templateId 订阅消息模板ID
+redirectUrl 授权后重定向的地址，会带上openid、template_id、action、scene、reserved参数
+reserved 用于保持请求和回调的状态，授权后原样带回，可用于防止csrf攻击
+*/
+func (t *Trader) OnceSubscribeURL(scene int, templateId, redirectUrl, reserved string) string {
+	q := url.Values{}
+	q.Set("appid", t.AppId)
+	q.Set("scene", fmt.Sprint(scene))
+	q.Set("template_id", templateId)
+	q.Set("redirect_url", redirectUrl)
+	q.Set("reserved", reserved)
+	return SubscribeConfirmURL + "&" + q.Encode() + "#wechat_redirect"
+}
@@ -0,0 +1,181 @@
+package trader
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+/*
+  标签批量管理
+  批量打标签/取消标签每次最多50个openid，每个用户最多20个标签
+*/
+
+const (
+	BatchTagLimit  = 50
+	MaxTagsPerUser = 20
+)
+
+// 逐页遍历标签下的粉丝 用法同FansIterator
+func (t *Trader) TagUserIterator(tagid int, nextopenid string) *OpenIdIterator {
+	return newOpenIdIterator(nextopenid, func(cursor string) ([]string, string, int, error) {
+		ids, next, err := t.GetUserByTag(tagid, cursor)
+		return ids, next, 0, err
+	})
+}
+
+// 标签下的全部粉丝
+func (t *Trader) GetAllUserByTag(tagid int) (openids []string, err error) {
+	it := t.TagUserIterator(tagid, "")
+	for it.Next() {
+		openids = append(openids, it.Page()...)
+	}
+	err = it.Err()
+	return
+}
+
+type TagSyncOptions struct {
+	// 受管理的标签 不在其中的标签不会被增删
+	Tags []int
+	// 返回用户应有的受管理标签，结果中不受管理的标签会被忽略
+	Rule func(u UserInfo) []int
+	// 每秒调用批量接口的次数 <=0表示不限速
+	Rate  float64
+	Burst int
+	// 为true时只计算变更，不调用接口
+	DryRun  bool
+	Workers int
+}
+
+// 批量打标签接口的操作
+type TagAction string
+
+const (
+	TagActionTag   TagAction = "batchtagging"
+	TagActionUntag TagAction = "batchuntagging"
+)
+
+// 同步标签时失败的分片 Start、End为分片在该标签的增删列表中的下标范围
+type TagChunkError struct {
+	TagId  int
+	Action TagAction
+	ChunkError
+}
+
+type TagSyncReport struct {
+	Add    map[int][]string //tagid -> 需要添加该标签的openid
+	Remove map[int][]string //tagid -> 需要移除该标签的openid
+	// 添加后标签数会超过20个而未添加的用户 openid -> 未添加的标签
+	Overflow map[string][]int
+	Errors   []TagChunkError
+}
+
+// 根据规则同步全部关注者的受管理标签
+// 先遍历关注者计算增删集合，再按标签分片调用批量接口，某个分片失败不影响其他分片
+func (t *Trader) SyncTags(ctx context.Context, opt TagSyncOptions) (report TagSyncReport, err error) {
+	if opt.Rule == nil {
+		err = errors.New("TagSyncOptions.Rule不能为空")
+		return
+	}
+	report.Add = make(map[int][]string)
+	report.Remove = make(map[int][]string)
+	report.Overflow = make(map[string][]int)
+
+	managed := make(map[int]bool, len(opt.Tags))
+	for _, id := range opt.Tags {
+		managed[id] = true
+	}
+
+	s := t.StreamUserInfo(ctx, "", opt.Workers)
+	for u := range s.C {
+		planUserTags(&report, managed, u, opt.Rule(u))
+	}
+	_, err = s.Wait()
+	if err != nil || opt.DryRun {
+		return
+	}
+
+	l := newLimiter(opt.Rate, opt.Burst)
+	apply := func(action TagAction, plan map[int][]string) error {
+		tags := make([]int, 0, len(plan))
+		for id := range plan {
+			tags = append(tags, id)
+		}
+		sort.Ints(tags)
+		for _, tagid := range tags {
+			ids := plan[tagid]
+			for i, chunk := range chunkStrings(ids, BatchTagLimit) {
+				if err := l.Wait(ctx); err != nil {
+					return err
+				}
+				if err := t.membersTagging(string(action), chunk, tagid); err != nil {
+					report.Errors = append(report.Errors, TagChunkError{
+						TagId:  tagid,
+						Action: action,
+						ChunkError: ChunkError{
+							Start:   i * BatchTagLimit,
+							End:     i*BatchTagLimit + len(chunk),
+							OpenIds: chunk,
+							Err:     err,
+						},
+					})
+				}
+			}
+		}
+		return nil
+	}
+	// 先移除再添加，为新标签腾出数量
+	err = apply(TagActionUntag, report.Remove)
+	if err != nil {
+		return
+	}
+	err = apply(TagActionTag, report.Add)
+	return
+}
+
+// 重试SyncTags报告中失败的分片 返回仍然失败的分片
+func (t *Trader) RetryTagChunks(errs []TagChunkError) (failed []TagChunkError) {
+	for _, e := range errs {
+		if err := t.membersTagging(string(e.Action), e.OpenIds, e.TagId); err != nil {
+			e.Err = err
+			failed = append(failed, e)
+		}
+	}
+	return
+}
+
+func planUserTags(report *TagSyncReport, managed map[int]bool, u UserInfo, desired []int) {
+	want := make(map[int]bool)
+	for _, id := range desired {
+		if managed[id] {
+			want[id] = true
+		}
+	}
+	has := make(map[int]bool, len(u.TagidList))
+	for _, id := range u.TagidList {
+		has[id] = true
+	}
+
+	count := len(u.TagidList)
+	for _, id := range u.TagidList {
+		if managed[id] && !want[id] {
+			report.Remove[id] = append(report.Remove[id], u.Openid)
+			count--
+		}
+	}
+	adds := make([]int, 0, len(want))
+	for id := range want {
+		if !has[id] {
+			adds = append(adds, id)
+		}
+	}
+	sort.Ints(adds)
+	for _, id := range adds {
+		if count >= MaxTagsPerUser {
+			report.Overflow[u.Openid] = append(report.Overflow[u.Openid], id)
+			continue
+		}
+		report.Add[id] = append(report.Add[id], u.Openid)
+		count++
+	}
+}
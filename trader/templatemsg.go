@@ -158,3 +158,12 @@ func (t *Trader) SendTemplateMsg(jsonContext string) (msgid int, err error) {
 	}
 	return
 }
+
+//发送模板消息 结构体形式
+func (t *Trader) SendTemplate(msg TemplateMsg) (msgid int, err error) {
+	str, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	return t.SendTemplateMsg(string(str))
+}
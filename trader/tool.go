@@ -2,6 +2,7 @@ package trader
 
 import (
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
@@ -23,3 +24,24 @@ func GetRandStr(a int) string {
 	}
 	return string(result)
 }
+
+// ErrCode 从接口返回的错误中解析出微信的errcode，无法解析时返回0
+func ErrCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var r Res
+	if json.Unmarshal([]byte(err.Error()), &r) != nil {
+		return 0
+	}
+	return r.ErrCode
+}
+
+// 需要刷新access_token后重试的errcode
+func isTokenErrCode(code int) bool {
+	switch code {
+	case 40001, 40014, 42001:
+		return true
+	}
+	return false
+}
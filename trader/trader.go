@@ -11,17 +11,23 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/slrem/wechat/metrics"
 )
 
 type Trader struct {
-	AppId                string
-	AppSecret            string
-	Accesstoken          string
-	ExpiresIn            int64
-	JsapiTicket          string
-	JsapiTicketExpiresIn int64
-	mtx                  sync.Mutex
-	AccessTokenHandler   Handler
+	AppId                 string
+	AppSecret             string
+	Accesstoken           string
+	ExpiresIn             int64
+	JsapiTicket           string
+	JsapiTicketExpiresIn  int64
+	WxCardTicket          string
+	WxCardTicketExpiresIn int64
+	mtx                   sync.Mutex
+	ticketMtx             sync.Mutex
+	AccessTokenHandler    Handler
+	Metrics               metrics.Metrics //为nil时不收集指标
 }
 type Handler func() (AccessToken, error)
 
@@ -39,18 +45,14 @@ func NewTrader(appid, appsecret string, h Handler) (t *Trader, err error) {
 }
 
 func (t *Trader) GetAccessToken() (a AccessToken, err error) {
+	defer func() { t.tokenRefreshed(err) }()
 	h := t.AccessTokenHandler
 	if h != nil {
 		a, err = h()
 		return
 	}
 	surl := AccessTokenURL + t.AppId + "&secret=" + t.AppSecret
-	res, err := http.Get(surl)
-	if err != nil {
-		return
-	}
-	defer res.Body.Close()
-	b, err := ioutil.ReadAll(res.Body)
+	b, err := t.Get(surl)
 	if err != nil {
 		return
 	}
@@ -130,18 +132,7 @@ func (t *Trader) upload(materialtype string, data []byte, title, introduction st
 
 	w.Close()
 	surl := UploadURL + t.Accesstoken + `&type=` + materialtype
-	req, err := http.NewRequest("POST", surl, buf)
-	if err != nil {
-		return
-	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
-	var client http.Client
-	res, err := client.Do(req)
-	if err != nil {
-		return
-	}
-	defer res.Body.Close()
-	aaa, err := ioutil.ReadAll(res.Body)
+	aaa, err := t.PostMultipart(surl, w.FormDataContentType(), buf)
 	if err != nil {
 		return
 	}
@@ -164,6 +155,8 @@ func (t *Trader) upload(materialtype string, data []byte, title, introduction st
 }
 
 func (t *Trader) Get(surl string) (b []byte, err error) {
+	start := time.Now()
+	defer func() { t.observe(surl, start, b, err) }()
 	resp, err := http.Get(surl)
 	if err != nil {
 		return
@@ -174,6 +167,8 @@ func (t *Trader) Get(surl string) (b []byte, err error) {
 }
 
 func (t *Trader) PostJson(surl, jsonstr string) (b []byte, err error) {
+	start := time.Now()
+	defer func() { t.observe(surl, start, b, err) }()
 	client := &http.Client{}
 	req, err := http.NewRequest("POST", surl, bytes.NewReader([]byte(jsonstr)))
 	if err != nil {
@@ -189,6 +184,19 @@ func (t *Trader) PostJson(surl, jsonstr string) (b []byte, err error) {
 	return
 }
 
+// 上传multipart表单 contentType为multipart.Writer.FormDataContentType()
+func (t *Trader) PostMultipart(surl, contentType string, body io.Reader) (b []byte, err error) {
+	start := time.Now()
+	defer func() { t.observe(surl, start, b, err) }()
+	r, err := http.Post(surl, contentType, body)
+	if err != nil {
+		return
+	}
+	defer r.Body.Close()
+	b, err = ioutil.ReadAll(r.Body)
+	return
+}
+
 func (t *Trader) AddImageMaterial(data []byte) (mediaId, url string, err error) {
 	return t.upload("image", data, "", "")
 }
@@ -439,12 +447,7 @@ func (t *Trader) SetKfAccountheadImg(kfaccount string, imgdata []byte) (err erro
 		return
 	}
 	w.Close()
-	resp, err := http.Post(surl, w.FormDataContentType(), buf)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := t.PostMultipart(surl, w.FormDataContentType(), buf)
 	if err != nil {
 		return
 	}
@@ -466,12 +469,7 @@ func (t *Trader) GetKfList() (list KfAccountList, err error) {
 		return
 	}
 	surl := GetkfListURL + t.Accesstoken
-	resp, err := http.Get(surl)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := t.Get(surl)
 	if err != nil {
 		return
 	}
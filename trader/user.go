@@ -181,49 +181,31 @@ func (t *Trader) GetUserByTag(tagid int, nextopenid string) (useropenid []string
 	return
 }
 
-//批量为用户打标签
+//批量为用户打标签 超过50个openid时自动拆分，部分失败时返回*BatchError
 func (t *Trader) BatchTagToUsers(useropenids []string, tagid int) (err error) {
-	err = t.CheckAccessTokenLive()
-	if err != nil {
-		return
-	}
-	surl := "https://api.weixin.qq.com/cgi-bin/tags/members/batchtagging?access_token=" + t.Accesstoken
-	var p struct {
-		OpenIds []string `json:"openid_list"`
-		TagId   int      `json:"tagid"`
-	}
-	p.OpenIds, p.TagId = useropenids, tagid
-	str, err := json.Marshal(p)
-	if err != nil {
-		return
-	}
-	b, err := t.PostJson(surl, string(str))
-	if err != nil {
-		return
-	}
-	var r Res
-	err = json.Unmarshal(b, &r)
-	if err != nil {
-		return
-	}
-	if r.ErrCode != 0 {
-		err = errors.New(string(b))
-	}
-	return
+	return t.batchChunks(useropenids, BatchTagLimit, func(ids []string) error {
+		return t.membersTagging("batchtagging", ids, tagid)
+	})
 }
 
-//批量为用户取消标签
+//批量为用户取消标签 超过50个openid时自动拆分，部分失败时返回*BatchError
 func (t *Trader) BatchCancelTag(useropenid []string, tagid int) (err error) {
+	return t.batchChunks(useropenid, BatchTagLimit, func(ids []string) error {
+		return t.membersTagging("batchuntagging", ids, tagid)
+	})
+}
+
+func (t *Trader) membersTagging(action string, useropenids []string, tagid int) (err error) {
 	err = t.CheckAccessTokenLive()
 	if err != nil {
 		return
 	}
-	surl := "https://api.weixin.qq.com/cgi-bin/tags/members/batchuntagging?access_token=" + t.Accesstoken
+	surl := TagsURL + "members/" + action + "?access_token=" + t.Accesstoken
 	var p struct {
 		OpenIds []string `json:"openid_list"`
 		TagId   int      `json:"tagid"`
 	}
-	p.OpenIds, p.TagId = useropenid, tagid
+	p.OpenIds, p.TagId = useropenids, tagid
 	str, err := json.Marshal(p)
 	if err != nil {
 		return
@@ -314,7 +296,7 @@ func (t *Trader) GetUserInfo(openid string) (user UserInfo, err error) {
 	if err != nil {
 		return
 	}
-	surl := "https://api.weixin.qq.com/cgi-bin/user/info?access_token=" + t.Accesstoken + "&openid=" + openid + "&lang=zh_CN "
+	surl := "https://api.weixin.qq.com/cgi-bin/user/info?access_token=" + t.Accesstoken + "&openid=" + openid + "&lang=zh_CN"
 	b, err := t.Get(surl)
 	if err != nil {
 		return
@@ -4,8 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"strings"
 	"time"
 )
 
@@ -13,49 +12,88 @@ type Jsapi_ticket struct {
 	ErrCode    int    `json:"errcode"`
 	ErrMsg     string `json:"errmsg"`
 	Ticket     string `json:"ticket"`
-	Expires_in int64  `json:"Expires_in"`
+	Expires_in int64  `json:"expires_in"`
 }
 
-func (t *Trader) isJTAlive() bool {
-	if t.JsapiTicket == "" || t.JsapiTicketExpiresIn-time.Now().Unix() < 120 {
+func ticketAlive(ticket string, expiresIn int64) bool {
+	if ticket == "" || expiresIn-time.Now().Unix() < 120 {
 		return false
 	}
 	return true
 }
 
 func (t *Trader) GetJsapiTicket() (ticket string, err error) {
-	t.mtx.Lock()
-	if !t.isJTAlive() {
-		err = t.httpGetJsapi_ticket()
-	}
-	ticket = t.JsapiTicket
-	t.mtx.Unlock()
-	return
+	return t.getTicket("jsapi", &t.JsapiTicket, &t.JsapiTicketExpiresIn)
 }
+
 func (t *Trader) SetJsapiTicket(ticket string, Expires_in int64) {
+	t.mtx.Lock()
 	t.JsapiTicket, t.JsapiTicketExpiresIn = ticket, Expires_in
+	t.mtx.Unlock()
+}
+
+// 卡券api_ticket 用于chooseCard、addCard签名
+func (t *Trader) GetWxCardTicket() (ticket string, err error) {
+	return t.getTicket("wx_card", &t.WxCardTicket, &t.WxCardTicketExpiresIn)
+}
+
+func (t *Trader) SetWxCardTicket(ticket string, Expires_in int64) {
+	t.mtx.Lock()
+	t.WxCardTicket, t.WxCardTicketExpiresIn = ticket, Expires_in
+	t.mtx.Unlock()
 }
 
-func (t *Trader) httpGetJsapi_ticket() (err error) {
-	res, err := http.Get("https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=" + t.Accesstoken + "&type=jsapi")
+// 读取缓存的ticket，过期时重新获取，access_token失效时刷新后重试一次
+func (t *Trader) getTicket(ticketType string, ticket *string, expiresIn *int64) (string, error) {
+	t.mtx.Lock()
+	cur, alive := *ticket, ticketAlive(*ticket, *expiresIn)
+	t.mtx.Unlock()
+	if alive {
+		return cur, nil
+	}
+
+	t.ticketMtx.Lock()
+	defer t.ticketMtx.Unlock()
+	t.mtx.Lock()
+	cur, alive = *ticket, ticketAlive(*ticket, *expiresIn)
+	t.mtx.Unlock()
+	if alive {
+		return cur, nil
+	}
+
+	err := t.CheckAccessTokenLive()
 	if err != nil {
-		return
+		return "", err
+	}
+	jt, err := t.httpGetTicket(ticketType)
+	if isTokenErrCode(ErrCode(err)) {
+		err = t.FlushAccessToken()
+		if err != nil {
+			return "", err
+		}
+		jt, err = t.httpGetTicket(ticketType)
 	}
-	defer res.Body.Close()
-	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	t.mtx.Lock()
+	*ticket, *expiresIn = jt.Ticket, jt.Expires_in+time.Now().Unix()
+	t.mtx.Unlock()
+	return jt.Ticket, nil
+}
+
+func (t *Trader) httpGetTicket(ticketType string) (jt Jsapi_ticket, err error) {
+	b, err := t.Get("https://api.weixin.qq.com/cgi-bin/ticket/getticket?access_token=" + t.Accesstoken + "&type=" + ticketType)
 	if err != nil {
 		return
 	}
-	var jt Jsapi_ticket
 	err = json.Unmarshal(b, &jt)
 	if err != nil {
 		return
 	}
 	if jt.ErrCode != 0 {
 		err = errors.New(string(b))
-		return
 	}
-	t.SetJsapiTicket(jt.Ticket, jt.Expires_in+time.Now().Unix())
 	return
 }
 
@@ -66,7 +104,11 @@ type WebConf struct {
 	Signature string
 }
 
+// url为当前网页的地址，#及其后面部分会被去掉
 func (t *Trader) WebConfig(url string) (wf WebConf, err error) {
+	if i := strings.Index(url, "#"); i >= 0 {
+		url = url[:i]
+	}
 	wf.AppId = t.AppId
 	ticket, err := t.GetJsapiTicket()
 	if err != nil {
@@ -3,12 +3,16 @@ package wechat
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"runtime/debug"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/slrem/wechat/metrics"
 	"github.com/slrem/wechat/trader"
 	"github.com/slrem/wechat/wxencrypter"
 )
@@ -26,12 +30,24 @@ type (
 		securityMode bool
 		encrypter    *wxencrypter.Encrypter
 
-		router *Router
-		trader *trader.Trader
+		router         *Router
+		trader         *trader.Trader
+		replyTemplates *ReplyTemplates
+		autoReply      *AutoReplyEngine
+		metrics        metrics.Metrics
+		ipAllowlist    *IPAllowlist
 
 		WechatErrorHandler WechatErrorHandler
 		defaultHandler     Handler
 		middleware         []Middleware
+		callbackHooks      []CallbackHook
+
+		// 被动回复文本超长时的处理方式，默认返回错误
+		TextOverflow TextOverflow
+		// TextOverflowSplit时后续客服消息发送失败的回调
+		FollowUpErrorHandler func(openid string, err error)
+		// 被动回复写出后等待多久再发送后续客服消息，默认1秒
+		FollowUpDelay time.Duration
 	}
 
 	Middleware func(Handler) Handler
@@ -39,6 +55,9 @@ type (
 	Handler func(Context) error
 
 	WechatErrorHandler func(error, Context) error
+
+	// 回调处理完成、最终回复已写出后调用 err为解析或处理出错时的错误
+	CallbackHook func(c Context, err error, elapsed time.Duration)
 )
 
 const (
@@ -111,10 +130,15 @@ func (w *Wechat) checkSignature(timestamp, nonce, signature string) bool {
 }
 
 func (w *Wechat) Server(rw http.ResponseWriter, r *http.Request) {
+	if !w.allowIP(r) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
 	timestamp := r.URL.Query().Get("timestamp")
 	nonce := r.URL.Query().Get("nonce")
 	signature := r.URL.Query().Get("signature")
 	if !w.checkSignature(timestamp, nonce, signature) {
+		w.Metrics().Inc(metrics.CallbackRejectedTotal, "reason", "signature")
 		rw.WriteHeader(400)
 		return
 	}
@@ -123,38 +147,138 @@ func (w *Wechat) Server(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method == "POST" {
+		start := time.Now()
 		c := newContext(rw, r, w)
 		err := c.parse()
+		parsed := err == nil
+		if parsed {
+			err = w.handle(c)
+		}
 		if err != nil {
-			if h := w.WechatErrorHandler; h != nil {
-				h(err, c)
-			}
-			return
+			w.handleError(err, c)
 		}
-
-		w.router.Find(c)
-
-		h := c.Handler()
-		if h == nil {
-			h = w.DefaultHandler()
+		// 解析失败已计入CallbackRejectedTotal
+		if parsed {
+			w.observe(c, start, err)
 		}
-		for i := len(w.middleware) - 1; i >= 0; i-- {
-			h = w.middleware[i](h)
+		w.followUp(rw, c)
+		for _, hook := range w.callbackHooks {
+			hook(c, err, time.Since(start))
 		}
+	}
+}
 
-		if err := h(c); err != nil {
-			if h := w.WechatErrorHandler; h != nil {
-				h(err, c)
-			}
+// 执行路由和中间件
+func (w *Wechat) handle(c *context) (err error) {
+	w.route(c)
+
+	h := c.Handler()
+	if h == nil {
+		h = w.DefaultHandler()
+	}
+	// 先在内层恢复panic，使中间件能看到PanicError
+	inner := h
+	h = func(c Context) error { return w.run(inner, c) }
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		h = w.middleware[i](h)
+	}
+
+	return w.run(h, c)
+}
+
+// 添加回调完成后的钩子 解析失败的回调也会调用
+func (w *Wechat) OnCallback(h ...CallbackHook) {
+	w.callbackHooks = append(w.callbackHooks, h...)
+}
+
+// 被动回复写出后再发送超长文本的剩余部分，保证用户先收到第一段
+func (w *Wechat) followUp(rw http.ResponseWriter, c *context) {
+	parts := c.reply.followUp
+	if len(parts) == 0 || !c.reply.written {
+		return
+	}
+	if f, ok := rw.(http.Flusher); ok {
+		f.Flush()
+	}
+	go w.sendFollowUp(c.Request().FromUserName(), parts)
+}
+
+// 先匹配自动回复，未命中时使用已注册的处理函数，最后使用默认处理函数
+// AutoReplyFallbackOnly时已注册处理函数的消息类型不匹配自动回复
+func (w *Wechat) route(c Context) {
+	if ar := w.autoReply; ar != nil && (!ar.AutoReplyFallbackOnly || w.router.Get(c.Request().MsgType(), "") == nil) {
+		if h := ar.find(c.Request()); h != nil {
+			c.SetHandler(h)
 			return
 		}
 	}
+	w.router.Find(c)
+}
+
+// 处理函数中的panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("wechat: handler panic: %v", e.Value)
+}
+
+// 执行h 将panic转换为PanicError
+func (w *Wechat) run(h Handler, c Context) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return h(c)
+}
+
+// 交给WechatErrorHandler处理，之后仍未回复时回复success，避免微信重试和用户看到错误提示
+func (w *Wechat) handleError(err error, c Context) {
+	if h := w.WechatErrorHandler; h != nil {
+		w.run(func(c Context) error { return h(err, c) }, c)
+	}
+	if !c.Replied() {
+		c.Response().Success()
+	}
+}
+
+// 记录回调的处理耗时和最终的回复结果
+func (w *Wechat) observe(c Context, start time.Time, err error) {
+	m := w.Metrics()
+	msgType := c.Request().MsgType().String()
+	m.Inc(metrics.CallbacksTotal, "msg_type", msgType)
+	m.Observe(metrics.CallbackDuration, time.Since(start).Seconds(), "msg_type", msgType)
+	replyType, result := c.ReplyType(), "ok"
+	if replyType == "" {
+		replyType = "none"
+	}
+	if err != nil {
+		result = "error"
+	}
+	m.Inc(metrics.CallbackRepliesTotal, "reply_type", replyType, "result", result)
+}
+
+// 设置回调和接口调用的指标收集，同时作用于Trader
+func (w *Wechat) SetMetrics(m metrics.Metrics) {
+	w.metrics = m
+	if w.trader != nil {
+		w.trader.Metrics = m
+	}
+}
+
+// 当前的指标收集，未设置时为metrics.Nop
+func (w *Wechat) Metrics() metrics.Metrics {
+	return metrics.OrNop(w.metrics)
 }
 
 func (w *Wechat) body(r *http.Request) (data []byte, err error) {
 	data, err = ioutil.ReadAll(r.Body)
 
 	if err != nil {
+		w.Metrics().Inc(metrics.CallbackRejectedTotal, "reason", "read")
 		return
 	}
 
@@ -164,10 +288,13 @@ func (w *Wechat) body(r *http.Request) (data []byte, err error) {
 		nonce := r.URL.Query().Get("nonce")
 
 		msgSignature := r.URL.Query().Get("msg_signature")
-		data, err = w.Decrypt(msgSignature, timestamp, nonce, data)
+		var d []byte
+		d, err = w.Decrypt(msgSignature, timestamp, nonce, data)
 		if err != nil {
+			w.Metrics().Inc(metrics.CallbackRejectedTotal, "reason", "decrypt")
 			return
 		}
+		data = d
 	}
 
 	return
@@ -181,6 +308,23 @@ func (w *Wechat) Encrypt(d []byte) (b []byte, err error) {
 	return w.encrypter.Encrypt(d)
 }
 
+// 将超长文本的剩余部分依次以客服消息发送
+func (w *Wechat) sendFollowUp(openid string, parts []string) {
+	delay := w.FollowUpDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	time.Sleep(delay)
+	for _, p := range parts {
+		if err := w.trader.SendTextMsg(openid, p); err != nil {
+			if w.FollowUpErrorHandler != nil {
+				w.FollowUpErrorHandler(openid, err)
+			}
+			return
+		}
+	}
+}
+
 func (w *Wechat) Use(m ...Middleware) {
 	w.middleware = append(w.middleware, m...)
 }
@@ -272,3 +416,31 @@ func (w *Wechat) LocationSelectEven(h Handler) {
 func (w *Wechat) TemplateSendJobFinishEvent(h Handler) {
 	w.add(TemplateSendJobFinishEventType, "", h)
 }
+
+func (w *Wechat) SubscribeMsgPopupEvent(h Handler) {
+	w.add(SubscribeMsgPopupEventType, "", h)
+}
+
+func (w *Wechat) SubscribeMsgChangeEvent(h Handler) {
+	w.add(SubscribeMsgChangeEventType, "", h)
+}
+
+func (w *Wechat) SubscribeMsgSentEvent(h Handler) {
+	w.add(SubscribeMsgSentEventType, "", h)
+}
+
+func (w *Wechat) MassSendJobFinishEvent(h Handler) {
+	w.add(MassSendJobFinishEventType, "", h)
+}
+
+func (w *Wechat) KfCreateSessionEvent(h Handler) {
+	w.add(KfCreateSessionEventType, "", h)
+}
+
+func (w *Wechat) KfCloseSessionEvent(h Handler) {
+	w.add(KfCloseSessionEventType, "", h)
+}
+
+func (w *Wechat) KfSwitchSessionEvent(h Handler) {
+	w.add(KfSwitchSessionEventType, "", h)
+}